@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bookmarks are short, so anything bigger than this is a mistake.
+const maxBookmarkSize = 4096
+
+// Add the /admin endpoints to mux, each requiring the admin bearer token.
+func (depper *Depper) registerAdminRoutes(mux *http.ServeMux) {
+	routes := map[string]http.HandlerFunc{
+		"POST /admin/ingestors/{name}/run":        depper.handleRun,
+		"POST /admin/ingestors/{name}/pause":      depper.handlePause,
+		"POST /admin/ingestors/{name}/resume":     depper.handleResume,
+		"GET /admin/ingestors/{name}/bookmark":    depper.handleGetBookmark,
+		"PUT /admin/ingestors/{name}/bookmark":    depper.handleSetBookmark,
+		"DELETE /admin/ingestors/{name}/bookmark": depper.handleResetBookmark,
+		"POST /admin/republish":                   depper.handleRepublish,
+		"GET /admin/dead-letters":                 depper.handleDeadLetters,
+		"POST /admin/dead-letters/replay":         depper.handleReplayDeadLetters,
+	}
+	for pattern, handler := range routes {
+		mux.Handle(pattern, depper.requireAdmin(handler))
+	}
+}
+
+func (depper *Depper) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(depper.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Find a registered ingestor by name, writing a 404 if there isn't one.
+func (depper *Depper) scheduledIngestor(w http.ResponseWriter, r *http.Request) (*scheduledIngestor, bool) {
+	name := r.PathValue("name")
+
+	depper.mu.Lock()
+	defer depper.mu.Unlock()
+
+	for _, scheduled := range depper.scheduled {
+		if scheduled.ingestor.Name() == name {
+			return scheduled, true
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("unknown ingestor %q", name), http.StatusNotFound)
+	return nil, false
+}
+
+// Start a run now, without waiting for the next tick. The run carries on in
+// the background and shows up on /status.
+func (depper *Depper) handleRun(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	if scheduled.currentStatus().Running {
+		http.Error(w, fmt.Sprintf("%s is already running", scheduled.ingestor.Name()), http.StatusConflict)
+		return
+	}
+
+	log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name()}).Info("run triggered by admin API")
+	go func() {
+		// Errors are already logged and recorded in the ingestor's status.
+		_ = depper.ingestAndPublish(scheduled)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (depper *Depper) handlePause(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	scheduled.pause()
+	log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name()}).Info("paused by admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (depper *Depper) handleResume(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	scheduled.resume()
+	log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name()}).Info("resumed by admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (depper *Depper) handleGetBookmark(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	bookmark, err := ingestors.GetBookmark(r.Context(), scheduled.ingestor)
+	if errors.Is(err, ingestors.ErrNoBookmark) {
+		http.Error(w, fmt.Sprintf("%s has no bookmark", scheduled.ingestor.Name()), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintln(w, bookmark)
+}
+
+// Overwrite the bookmark with the request body. A run that is already in
+// progress will still commit its own bookmark when it finishes.
+func (depper *Depper) handleSetBookmark(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBookmarkSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bookmark := strings.TrimSpace(string(body))
+	if bookmark == "" {
+		http.Error(w, "expected the bookmark as the request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := ingestors.SetBookmark(r.Context(), scheduled.ingestor, bookmark); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name(), "bookmark": bookmark}).Info("bookmark set by admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (depper *Depper) handleResetBookmark(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := depper.scheduledIngestor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ingestors.ResetBookmark(r.Context(), scheduled.ingestor); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name()}).Info("bookmark reset by admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Publish the release in the JSON body even if it was published recently.
+// An optional ?ttl= sets how long it is then deduplicated for.
+func (depper *Depper) handleRepublish(w http.ResponseWriter, r *http.Request) {
+	ttl := defaultTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err == nil {
+			err = publishers.ValidateTTL(parsed)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid ttl: %s", err), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	var packageVersion data.PackageVersion
+	if err := json.NewDecoder(r.Body).Decode(&packageVersion); err != nil {
+		http.Error(w, fmt.Sprintf("invalid release: %s", err), http.StatusBadRequest)
+		return
+	}
+	if packageVersion.Platform == "" || packageVersion.Name == "" || packageVersion.Version == "" {
+		http.Error(w, "platform, name and version are required", http.StatusBadRequest)
+		return
+	}
+	if packageVersion.Action != "" && !packageVersion.Action.IsValid() {
+		http.Error(w, fmt.Sprintf("unknown action %q", packageVersion.Action), http.StatusBadRequest)
+		return
+	}
+	if packageVersion.CreatedAt.IsZero() {
+		packageVersion.CreatedAt = time.Now()
+	}
+
+	if err := depper.pipeline.ForcePublish(r.Context(), ttl, packageVersion); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"platform": packageVersion.Platform, "name": packageVersion.Name, "version": packageVersion.Version}).Info("republished by admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (depper *Depper) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetters, err := depper.pipeline.DeadLetters(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(deadLetters); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("couldn't write dead letters")
+	}
+}
+
+// Replay every dead letter and report how many were published.
+func (depper *Depper) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	replayed, err := depper.pipeline.ReplayDeadLetters(r.Context())
+	response := struct {
+		Replayed int    `json:"replayed"`
+		Error    string `json:"error,omitempty"`
+	}{Replayed: replayed}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("couldn't write replay result")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+	"github.com/robfig/cron/v3"
+)
+
+func newAdminTestDepper(t *testing.T) *Depper {
+	store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingestors.SetBookmarkStore(store)
+
+	scheduled := newScheduledIngestor(fakeIngestor{}, config.Ingestor{})
+	scheduled.cron = cron.New()
+
+	return &Depper{
+		pipeline:   publishers.NewPipeline(),
+		bookmarks:  store,
+		scheduled:  []*scheduledIngestor{scheduled},
+		adminToken: "secret",
+		ctx:        context.Background(),
+	}
+}
+
+func adminRequest(depper *Depper, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	depper.serveMux().ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	depper := newAdminTestDepper(t)
+
+	request := httptest.NewRequest("GET", "/admin/ingestors/fake/bookmark", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder := httptest.NewRecorder()
+	depper.serveMux().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status code = %d, want 401", recorder.Code)
+	}
+
+	depper.adminToken = ""
+	recorder = adminRequest(depper, "GET", "/admin/ingestors/fake/bookmark", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status code without an admin token configured = %d, want 404", recorder.Code)
+	}
+}
+
+func TestAdmin_Bookmark(t *testing.T) {
+	depper := newAdminTestDepper(t)
+
+	if code := adminRequest(depper, "GET", "/admin/ingestors/fake/bookmark", "").Code; code != http.StatusNotFound {
+		t.Errorf("get before set = %d, want 404", code)
+	}
+	if code := adminRequest(depper, "PUT", "/admin/ingestors/fake/bookmark", "1234\n").Code; code != http.StatusNoContent {
+		t.Errorf("set = %d, want 204", code)
+	}
+	recorder := adminRequest(depper, "GET", "/admin/ingestors/fake/bookmark", "")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "1234\n" {
+		t.Errorf("get = %d %q", recorder.Code, recorder.Body.String())
+	}
+	if code := adminRequest(depper, "DELETE", "/admin/ingestors/fake/bookmark", "").Code; code != http.StatusNoContent {
+		t.Errorf("reset = %d, want 204", code)
+	}
+	if code := adminRequest(depper, "GET", "/admin/ingestors/fake/bookmark", "").Code; code != http.StatusNotFound {
+		t.Errorf("get after reset = %d, want 404", code)
+	}
+	if code := adminRequest(depper, "GET", "/admin/ingestors/missing/bookmark", "").Code; code != http.StatusNotFound {
+		t.Errorf("unknown ingestor = %d, want 404", code)
+	}
+}
+
+func TestAdmin_PauseResume(t *testing.T) {
+	depper := newAdminTestDepper(t)
+	scheduled := depper.scheduled[0]
+
+	if code := adminRequest(depper, "POST", "/admin/ingestors/fake/pause", "").Code; code != http.StatusNoContent {
+		t.Errorf("pause = %d, want 204", code)
+	}
+	if !scheduled.currentStatus().Paused {
+		t.Error("expected the ingestor to be paused")
+	}
+	if code := adminRequest(depper, "POST", "/admin/ingestors/fake/resume", "").Code; code != http.StatusNoContent {
+		t.Errorf("resume = %d, want 204", code)
+	}
+	if scheduled.currentStatus().Paused {
+		t.Error("expected the ingestor to be resumed")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+	"github.com/librariesio/depper/redis"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: depper <command> [arguments]
+
+Commands:
+  run                                    start every enabled ingestor on its schedule (default)
+  ingest <ingestor> [--dry-run]          run a single ingestor once and publish what it finds,
+                                         or with --dry-run print it to stdout as JSON Lines
+  bookmark get <ingestor>                print an ingestor's bookmark
+  bookmark set <ingestor> <value>        overwrite an ingestor's bookmark
+  bookmark reset <ingestor>              delete an ingestor's bookmark so it starts from its default
+  dead-letters list                      print releases publishers gave up on, as JSON Lines
+  dead-letters replay                    send them back through the publishers that failed
+  publish [--ttl 24h] [--force] [--action yank] <platform> <name> <version>
+                                         publish a single release through the pipeline,
+                                         with --force even if it was published within the ttl
+
+Every command accepts --config <path>, which defaults to $CONFIG_FILE.
+`
+
+// Parse flags wherever they appear among the positional arguments, so that
+// "ingest npm --dry-run" works as well as "ingest --dry-run npm".
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// A context that is cancelled on SIGINT or SIGTERM, for one-off commands.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// Set up the configured bookmark store, connecting to Redis only if it needs it.
+func setupBookmarkStore(cfg *config.Config) (ingestors.BookmarkStore, error) {
+	if cfg.Bookmarks.UsesRedis() && redis.Client == nil {
+		if err := connectRedis(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := ingestors.NewBookmarkStore(cfg.Bookmarks.Store, cfg.Bookmarks.Path)
+	if err != nil {
+		return nil, err
+	}
+	ingestors.SetBookmarkStore(store)
+
+	return store, nil
+}
+
+func ingestCommand(args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the config file")
+	dryRun := flags.Bool("dry-run", false, "print what would be published without publishing it or moving the bookmark")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected an ingestor name\n\n%s", usage)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+	ingestor, err := findIngestor(positional[0])
+	if err != nil {
+		return err
+	}
+	scheduled := newScheduledIngestor(ingestor, cfg.Ingestors[ingestor.Name()])
+
+	if _, err := setupBookmarkStore(cfg); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if *dryRun {
+		return dryRunIngest(ctx, scheduled)
+	}
+
+	if redis.Client == nil {
+		if err := connectRedis(cfg); err != nil {
+			return err
+		}
+	}
+	pipeline, err := createPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	spool, err := openSpool(cfg, pipeline)
+	if err != nil {
+		return err
+	}
+
+	depper := &Depper{pipeline: pipeline, spool: spool, ctx: ctx, cancel: cancel}
+	if cfg.Lease.IsEnabled() {
+		depper.leaser = newLeaser(cfg.Lease.TTL)
+	}
+	err = depper.ingestAndPublish(scheduled)
+
+	// Publisher pools publish in the background, so wait for them
+	return errors.Join(err, drainPipeline(pipeline, cfg.Shutdown.DrainTimeout))
+}
+
+// Run the ingestor once and write what it found to stdout as JSON Lines,
+// leaving the pipeline, its dedup keys and the ingestor's bookmark untouched.
+func dryRunIngest(ctx context.Context, scheduled *scheduledIngestor) error {
+	// Keep stdout to just the JSON Lines so it can be piped into jq and friends.
+	logToStderr()
+
+	ctx, cancel := context.WithTimeout(ctx, scheduled.timeout)
+	defer cancel()
+
+	packageVersions, cursor, err := scheduled.ingestor.Ingest(ctx)
+
+	publisher := publishers.NewJSONLinesPublisher(os.Stdout)
+	for _, packageVersion := range packageVersions {
+		if err := publisher.Publish(packageVersion); err != nil {
+			return err
+		}
+	}
+	if cursor != nil {
+		log.WithFields(log.Fields{"ingestor": scheduled.ingestor.Name(), "bookmark": cursor.Value}).Info("dry run, not committing bookmark")
+	}
+
+	return err
+}
+
+func bookmarkCommand(args []string) error {
+	flags := flag.NewFlagSet("bookmark", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the config file")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		return fmt.Errorf("expected a bookmark action and an ingestor name\n\n%s", usage)
+	}
+	action, name := positional[0], positional[1]
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+	if _, err := findIngestor(name); err != nil {
+		return err
+	}
+	store, err := setupBookmarkStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	switch {
+	case action == "get" && len(positional) == 2:
+		bookmark, err := store.Get(ctx, name)
+		if errors.Is(err, ingestors.ErrNoBookmark) {
+			return fmt.Errorf("%s has no bookmark", name)
+		} else if err != nil {
+			return err
+		}
+		fmt.Println(bookmark)
+	case action == "set" && len(positional) == 3:
+		return store.Set(ctx, name, positional[2])
+	case action == "reset" && len(positional) == 2:
+		return store.Delete(ctx, name)
+	default:
+		return fmt.Errorf("unexpected bookmark arguments %q\n\n%s", positional, usage)
+	}
+
+	return nil
+}
+
+func publishCommand(args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the config file")
+	ttl := flags.Duration("ttl", defaultTTL, "skip publishing if this release was already published within the ttl")
+	force := flags.Bool("force", false, "publish even if this release was already published within the ttl")
+	action := flags.String("action", "", "what happened to the release: new, update, yank, unyank, delete or deprecate")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 3 {
+		return fmt.Errorf("expected a platform, name and version\n\n%s", usage)
+	}
+	if err := publishers.ValidateTTL(*ttl); err != nil {
+		return fmt.Errorf("invalid --ttl: %w", err)
+	}
+	if *action != "" && !data.Action(*action).IsValid() {
+		return fmt.Errorf("unknown action %q\n\n%s", *action, usage)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+
+	if err := connectRedis(cfg); err != nil {
+		return err
+	}
+	pipeline, err := createPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := openSpool(cfg, pipeline); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	packageVersion := data.PackageVersion{
+		Platform:  positional[0],
+		Name:      positional[1],
+		Version:   positional[2],
+		CreatedAt: time.Now(),
+		Action:    data.Action(*action),
+	}
+	if *force {
+		err = pipeline.ForcePublish(ctx, *ttl, packageVersion)
+	} else {
+		err = pipeline.PublishAll(ctx, *ttl, []data.PackageVersion{packageVersion})
+	}
+
+	return errors.Join(err, drainPipeline(pipeline, cfg.Shutdown.DrainTimeout))
+}
+
+func deadLettersCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("dead-letters", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the config file")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected list or replay\n\n%s", usage)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+
+	if err := connectRedis(cfg); err != nil {
+		return err
+	}
+	pipeline, err := createPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := openSpool(cfg, pipeline); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, drainPipeline(pipeline, cfg.Shutdown.DrainTimeout))
+	}()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	switch positional[0] {
+	case "list":
+		deadLetters, err := pipeline.DeadLetters(ctx)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		for _, deadLetter := range deadLetters {
+			if err := encoder.Encode(deadLetter); err != nil {
+				return err
+			}
+		}
+	case "replay":
+		replayed, err := pipeline.ReplayDeadLetters(ctx)
+		fmt.Printf("replayed %d dead letters\n", replayed)
+		return err
+	default:
+		return fmt.Errorf("unexpected dead-letters action %q\n\n%s", positional[0], usage)
+	}
+
+	return nil
+}
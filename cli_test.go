@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/librariesio/depper/ingestors"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantPositional []string
+		wantDryRun     bool
+		wantConfig     string
+	}{
+		{name: "flags first", args: []string{"--dry-run", "npm"}, wantPositional: []string{"npm"}, wantDryRun: true},
+		{name: "flags last", args: []string{"npm", "--dry-run"}, wantPositional: []string{"npm"}, wantDryRun: true},
+		{
+			name:           "flags between",
+			args:           []string{"set", "--config", "depper.yml", "npm", "42"},
+			wantPositional: []string{"set", "npm", "42"},
+			wantConfig:     "depper.yml",
+		},
+		{name: "no flags", args: []string{"get", "npm"}, wantPositional: []string{"get", "npm"}},
+		{name: "nothing", args: nil, wantPositional: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			dryRun := flags.Bool("dry-run", false, "")
+			configPath := flags.String("config", "", "")
+
+			positional, err := parseArgs(flags, test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(positional, test.wantPositional) || *dryRun != test.wantDryRun || *configPath != test.wantConfig {
+				t.Errorf("got %q, dry run %t, config %q", positional, *dryRun, *configPath)
+			}
+		})
+	}
+}
+
+func TestParseArgs_UnknownFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if _, err := parseArgs(flags, []string{"npm", "--dry-rnu"}); err == nil {
+		t.Error("expected an unknown flag to be an error")
+	}
+}
+
+func TestBookmarkCommand(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "depper.yml")
+	storePath := filepath.Join(dir, "bookmarks.json")
+	if err := os.WriteFile(configPath, []byte("bookmarks:\n  store: file\n  path: "+storePath+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := ingestors.NewBookmarkStore("file", storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantErr      bool
+		wantBookmark string
+	}{
+		{name: "get without a bookmark", args: []string{"get", "npm"}, wantErr: true},
+		{name: "set", args: []string{"set", "npm", "42"}, wantBookmark: "42"},
+		{name: "get", args: []string{"get", "npm"}, wantBookmark: "42"},
+		{name: "reset", args: []string{"reset", "npm"}},
+		{name: "unknown ingestor", args: []string{"get", "left-pad"}, wantErr: true},
+		{name: "unknown action", args: []string{"move", "npm"}, wantErr: true},
+		{name: "missing name", args: []string{"get"}, wantErr: true},
+		{name: "missing value", args: []string{"set", "npm"}, wantErr: true},
+		{name: "extra value", args: []string{"reset", "npm", "42"}, wantErr: true},
+	}
+
+	// Each case runs against what the ones before it left in the store
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bookmarkCommand(append([]string{"--config", configPath}, test.args...))
+			if (err != nil) != test.wantErr {
+				t.Errorf("bookmarkCommand(%q) = %v, want an error: %t", test.args, err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			bookmark, _ := store.Get(context.Background(), "npm")
+			if bookmark != test.wantBookmark {
+				t.Errorf("bookmark = %q, want %q", bookmark, test.wantBookmark)
+			}
+		})
+	}
+}
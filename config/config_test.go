@@ -0,0 +1,113 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse(strings.NewReader(`
+bookmarks:
+  store: sqlite
+  path: /tmp/bookmarks.db
+ingestors:
+  npm:
+    schedule: "@every 1m"
+    ttl: 2h
+    timeout: 30s
+    base_url: http://localhost:5984/registry
+  packagist_drupal:
+    enabled: false
+publishers:
+  - type: sidekiq
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if config.Bookmarks.Store != "sqlite" || config.Bookmarks.Path != "/tmp/bookmarks.db" {
+		t.Errorf("unexpected bookmarks config %#v", config.Bookmarks)
+	}
+
+	npm := config.Ingestors["npm"]
+	if !npm.IsEnabled() {
+		t.Error("expected npm to be enabled")
+	}
+	if npm.Schedule != "@every 1m" {
+		t.Errorf("expected schedule @every 1m, got %s", npm.Schedule)
+	}
+	if npm.TTL != 2*time.Hour {
+		t.Errorf("expected ttl 2h, got %s", npm.TTL)
+	}
+	if npm.Timeout != 30*time.Second {
+		t.Errorf("expected timeout 30s, got %s", npm.Timeout)
+	}
+	if npm.BaseURL != "http://localhost:5984/registry" {
+		t.Errorf("expected base url to be set, got %s", npm.BaseURL)
+	}
+
+	if config.Ingestors["packagist_drupal"].IsEnabled() {
+		t.Error("expected packagist_drupal to be disabled")
+	}
+	if config.Ingestors["cocoapods"].IsEnabled() {
+		t.Error("expected cocoapods to stay disabled by default when unlisted")
+	}
+	if !config.Ingestors["rubygems"].IsEnabled() {
+		t.Error("expected unlisted ingestors to be enabled")
+	}
+
+	if len(config.Publishers) != 1 || config.Publishers[0].Type != "sidekiq" {
+		t.Errorf("unexpected publishers %#v", config.Publishers)
+	}
+}
+
+func TestParse_DefaultPublishers(t *testing.T) {
+	config, err := Parse(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(config.Publishers) != 2 {
+		t.Errorf("expected the default publishers, got %#v", config.Publishers)
+	}
+}
+
+func TestParse_UnknownField(t *testing.T) {
+	_, err := Parse(strings.NewReader(`
+ingestors:
+  npm:
+    schedual: "@every 1m"
+`))
+	if err == nil {
+		t.Error("expected an error for a misspelled field")
+	}
+}
+
+func TestPublisher_DecodeOptions(t *testing.T) {
+	config, err := Parse(strings.NewReader(`
+publishers:
+  - type: example
+    options:
+      queue: low
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var options struct {
+		Queue string `yaml:"queue"`
+	}
+	if err := config.Publishers[0].DecodeOptions(&options); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if options.Queue != "low" {
+		t.Errorf("expected queue low, got %s", options.Queue)
+	}
+}
+
+func TestLoad_Example(t *testing.T) {
+	if _, err := Load("../depper.example.yml"); err != nil {
+		t.Errorf("expected the example config to load, got %v", err)
+	}
+}
@@ -1,6 +1,47 @@
 package data
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// What happened to a release. Ingestors that can't tell leave it empty.
+type Action string
+
+const (
+	// The release was published
+	ActionNew Action = "new"
+	// An existing release changed, or a package announced as updated
+	ActionUpdate Action = "update"
+	// Hidden from listings, but still installable when pinned
+	ActionYank Action = "yank"
+	// A yank was undone
+	ActionUnyank Action = "unyank"
+	// Removed from the registry
+	ActionDelete Action = "delete"
+	// Marked as deprecated by its maintainers
+	ActionDeprecate Action = "deprecate"
+)
+
+var Actions = []Action{ActionNew, ActionUpdate, ActionYank, ActionUnyank, ActionDelete, ActionDeprecate}
+
+func (action Action) IsValid() bool {
+	return slices.Contains(Actions, action)
+}
+
+// Whether releases with this action are deduplicated and identified apart
+// from the release itself, so that e.g. a yank isn't skipped as a repeat of
+// the release. New releases, updates and releases without an action all mean
+// "go and look at this release", so they share one identity.
+func (action Action) IsDistinct() bool {
+	switch action {
+	case "", ActionNew, ActionUpdate:
+		return false
+	}
+
+	return true
+}
 
 // The information necessary for Libraries.to to look up a project and
 // retrieve additional, package manager-specific information.
@@ -11,6 +52,58 @@ type PackageVersion struct {
 	CreatedAt    time.Time
 	DiscoveryLag time.Duration // (time of depper discovery) - (creation time, as reported by repository)
 	Sequence     string        // arbitrary field for tracking the order of events and debugging
+	Delay        time.Duration // how long publishers that can schedule work should wait before it runs
+	Ingestor     string        // name of the ingestor that found it, if any
+	Action       Action        // what happened to it, if the ingestor knows
+}
+
+// The JSON shape of a PackageVersion. DiscoveryLag is in milliseconds, like
+// everywhere else we report it.
+type packageVersionJSON struct {
+	Platform       string    `json:"platform"`
+	Name           string    `json:"name"`
+	Version        string    `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	DiscoveryLagMs int64     `json:"discovery_lag_ms"`
+	Sequence       string    `json:"sequence,omitempty"`
+	DelayMs        int64     `json:"delay_ms,omitempty"`
+	Ingestor       string    `json:"ingestor,omitempty"`
+	Action         Action    `json:"action,omitempty"`
+}
+
+func (packageVersion PackageVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(packageVersionJSON{
+		Platform:       packageVersion.Platform,
+		Name:           packageVersion.Name,
+		Version:        packageVersion.Version,
+		CreatedAt:      packageVersion.CreatedAt,
+		DiscoveryLagMs: packageVersion.DiscoveryLag.Milliseconds(),
+		Sequence:       packageVersion.Sequence,
+		DelayMs:        packageVersion.Delay.Milliseconds(),
+		Ingestor:       packageVersion.Ingestor,
+		Action:         packageVersion.Action,
+	})
+}
+
+func (packageVersion *PackageVersion) UnmarshalJSON(encoded []byte) error {
+	var decoded packageVersionJSON
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return err
+	}
+
+	*packageVersion = PackageVersion{
+		Platform:     decoded.Platform,
+		Name:         decoded.Name,
+		Version:      decoded.Version,
+		CreatedAt:    decoded.CreatedAt,
+		DiscoveryLag: time.Duration(decoded.DiscoveryLagMs) * time.Millisecond,
+		Sequence:     decoded.Sequence,
+		Delay:        time.Duration(decoded.DelayMs) * time.Millisecond,
+		Ingestor:     decoded.Ingestor,
+		Action:       decoded.Action,
+	}
+
+	return nil
 }
 
 func MaxCreatedAt(packageVersions []PackageVersion) time.Time {
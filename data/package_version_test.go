@@ -0,0 +1,37 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPackageVersion_JSON(t *testing.T) {
+	packageVersion := PackageVersion{
+		Platform:     "npm",
+		Name:         "left-pad",
+		Version:      "1.3.0",
+		CreatedAt:    time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		DiscoveryLag: 1500 * time.Millisecond,
+		Sequence:     "42",
+		Action:       ActionYank,
+	}
+
+	encoded, err := json.Marshal(packageVersion)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := `{"platform":"npm","name":"left-pad","version":"1.3.0","created_at":"2024-05-06T07:08:09Z","discovery_lag_ms":1500,"sequence":"42","action":"yank"}`
+	if string(encoded) != expected {
+		t.Errorf("expected %s, got %s", expected, encoded)
+	}
+
+	var decoded PackageVersion
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if decoded != packageVersion {
+		t.Errorf("expected %#v, got %#v", packageVersion, decoded)
+	}
+}
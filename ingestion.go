@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/metrics"
+	"github.com/librariesio/depper/redis"
+	"github.com/robfig/cron/v3"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const defaultTTL = 24 * time.Hour
+
+var errRunInProgress = errors.New("ingestor is already running")
+
+// Whether releases are spooled because Redis doesn't answer, in which case
+// runs go ahead without a lease.
+func (depper *Depper) spoolsWhileRedisIsDown(ctx context.Context) bool {
+	if depper.spool == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	return redis.Client.Ping(ctx).Err() != nil
+}
+
+// How long a single ingestor run may take unless it implements ingestors.Timeouter
+const defaultIngestTimeout = 10 * time.Minute
+
+// An ingestor along with the settings it runs with, after applying config
+// overrides on top of its own defaults.
+type scheduledIngestor struct {
+	ingestor ingestors.PollingIngestor
+	schedule string
+	ttl      time.Duration
+	delay    time.Duration
+	timeout  time.Duration
+	cron     *cron.Cron
+
+	// Held for the duration of a run, so ticks that come around while the
+	// previous run is still going are skipped
+	running sync.Mutex
+
+	mu     sync.Mutex
+	status ingestorStatus
+	// When the bookmark was last committed by this process, or the time a
+	// time-based bookmark held when the ingestor was registered
+	bookmarkUpdatedAt time.Time
+}
+
+// What happened on an ingestor's most recent runs, as reported by /status
+type ingestorStatus struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	Running        bool       `json:"running"`
+	Paused         bool       `json:"paused"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastSuccessAt  *time.Time `json:"last_success_at,omitempty"`
+	LastResults    int        `json:"last_results"`
+	LastError      string     `json:"last_error,omitempty"`
+	Bookmark       string     `json:"bookmark,omitempty"`
+}
+
+func (scheduled *scheduledIngestor) recordStart() {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	now := time.Now()
+	scheduled.status.Running = true
+	scheduled.status.LastStartedAt = &now
+}
+
+func (scheduled *scheduledIngestor) recordFinish(results int, err error) {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	now := time.Now()
+	scheduled.status.Running = false
+	scheduled.status.LastFinishedAt = &now
+	scheduled.status.LastResults = results
+	scheduled.status.LastError = ""
+	if err != nil {
+		scheduled.status.LastError = err.Error()
+	} else {
+		scheduled.status.LastSuccessAt = &now
+	}
+}
+
+// Stop scheduling runs until resume is called. A run already in progress
+// carries on.
+func (scheduled *scheduledIngestor) pause() {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	if !scheduled.status.Paused {
+		scheduled.cron.Stop()
+		scheduled.status.Paused = true
+	}
+}
+
+func (scheduled *scheduledIngestor) resume() {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	if scheduled.status.Paused {
+		scheduled.cron.Start()
+		scheduled.status.Paused = false
+	}
+}
+
+func (scheduled *scheduledIngestor) recordBookmarkCommit() {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	scheduled.bookmarkUpdatedAt = time.Now()
+}
+
+// Until this process commits a bookmark, age a time-based bookmark from the
+// time it holds, so an ingestor that was stuck before a restart still shows
+// as stuck. Other bookmarks aren't aged until they're committed.
+func (scheduled *scheduledIngestor) loadBookmarkTime(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	bookmark, err := ingestors.GetBookmark(ctx, scheduled.ingestor)
+	if err != nil {
+		return
+	}
+	bookmarkTime, err := time.Parse(time.RFC3339, bookmark)
+	if err != nil {
+		return
+	}
+
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	if scheduled.bookmarkUpdatedAt.IsZero() {
+		scheduled.bookmarkUpdatedAt = bookmarkTime
+	}
+}
+
+func (scheduled *scheduledIngestor) lastBookmarkCommit() time.Time {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	return scheduled.bookmarkUpdatedAt
+}
+
+func (scheduled *scheduledIngestor) currentStatus() ingestorStatus {
+	scheduled.mu.Lock()
+	defer scheduled.mu.Unlock()
+
+	status := scheduled.status
+	status.Name = scheduled.ingestor.Name()
+	status.Schedule = scheduled.schedule
+
+	return status
+}
+
+func newScheduledIngestor(ingestor ingestors.PollingIngestor, ingestorConfig config.Ingestor) *scheduledIngestor {
+	scheduled := &scheduledIngestor{
+		ingestor: ingestor,
+		schedule: ingestor.Schedule(),
+		ttl:      defaultTTL,
+		timeout:  defaultIngestTimeout,
+	}
+
+	if ttler, ok := ingestor.(ingestors.TTLer); ok {
+		scheduled.ttl = ttler.TTL()
+	}
+	if delayer, ok := ingestor.(ingestors.Delayer); ok {
+		scheduled.delay = delayer.Delay()
+	}
+	if timeouter, ok := ingestor.(ingestors.Timeouter); ok {
+		scheduled.timeout = timeouter.Timeout()
+	}
+
+	if ingestorConfig.Schedule != "" {
+		scheduled.schedule = ingestorConfig.Schedule
+	}
+	if ingestorConfig.TTL != 0 {
+		scheduled.ttl = ingestorConfig.TTL
+	}
+	if ingestorConfig.Delay != 0 {
+		// Negative turns the ingestor's own delay off
+		scheduled.delay = max(ingestorConfig.Delay, 0)
+	}
+	if ingestorConfig.Timeout != 0 {
+		scheduled.timeout = ingestorConfig.Timeout
+	}
+	if ingestorConfig.BaseURL != "" {
+		if setter, ok := ingestor.(ingestors.BaseURLSetter); ok {
+			setter.SetBaseURL(ingestorConfig.BaseURL)
+		}
+	}
+
+	return scheduled
+}
+
+// Run the ingestor once, publish everything it found, and then commit its
+// bookmark. Failures are logged here and also returned for callers that
+// care, like the CLI. The run is skipped if the ingestor is already running
+// here or, with leases enabled, on another replica.
+func (depper *Depper) ingestAndPublish(scheduled *scheduledIngestor) (err error) {
+	ingestor := scheduled.ingestor
+	var packageVersions []data.PackageVersion
+
+	if !depper.beginRun() {
+		return errShuttingDown
+	}
+	defer depper.runs.Done()
+
+	if !scheduled.running.TryLock() {
+		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Warn("previous run still in progress, skipping")
+		return errRunInProgress
+	}
+	defer scheduled.running.Unlock()
+
+	runCtx, cancelRun := context.WithCancel(depper.ctx)
+	defer cancelRun()
+
+	if depper.leaser != nil {
+		lease, err := depper.leaser.acquire(runCtx, ingestor.Name(), cancelRun)
+		switch {
+		case err != nil && depper.spoolsWhileRedisIsDown(runCtx):
+			// Runs that need a bookmark from Redis still fail reading it, but
+			// feeds without one would otherwise miss what the outage hides
+			log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Warn("redis is down, running without a lease and spooling releases")
+		case err != nil:
+			log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error("couldn't acquire lease")
+			return err
+		case lease == nil:
+			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info("running on another replica, skipping")
+			return errRunInProgress
+		default:
+			defer lease.release()
+		}
+	}
+
+	scheduled.recordStart()
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{"ingestor": ingestor.Name(), "panic": r}).Error("ingestor panicked")
+			err = fmt.Errorf("ingestor panicked: %v", r)
+		}
+		scheduled.recordFinish(len(packageVersions), err)
+	}()
+	span := tracer.StartSpan("ingest_and_publish")
+	span.SetTag("ingestor", ingestor.Name())
+	defer span.Finish()
+
+	ctx, cancel := context.WithTimeout(runCtx, scheduled.timeout)
+	defer cancel()
+
+	ingestSpan := tracer.StartSpan("ingest", tracer.ChildOf(span.Context()))
+	packageVersions, cursor, ingestErr := ingestor.Ingest(ctx)
+	ingestSpan.Finish(tracer.WithError(ingestErr))
+	metrics.ObserveDiscovered(packageVersions)
+	if ingestErr != nil {
+		// Whatever was gathered before the failure is still published below.
+		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": ingestErr, "results": len(packageVersions)}).Error("ingestion failed")
+	}
+
+	for i := range packageVersions {
+		packageVersions[i].Delay = scheduled.delay
+		packageVersions[i].Ingestor = ingestor.Name()
+	}
+
+	// The run's own deadline may already have passed, so publishing and
+	// committing are only bounded by shutdown or losing the lease.
+	publishSpan := tracer.StartSpan("publish", tracer.ChildOf(span.Context()))
+	err = depper.pipeline.PublishAll(runCtx, scheduled.ttl, packageVersions)
+	publishSpan.Finish(tracer.WithError(err))
+	if err != nil {
+		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error("publishing failed, not committing bookmark")
+		return err
+	}
+
+	if err := cursor.Commit(runCtx); err != nil {
+		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error("committing bookmark failed")
+		return err
+	}
+	if cursor != nil {
+		scheduled.recordBookmarkCommit()
+	}
+
+	return ingestErr
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+)
+
+// Finds one release and proposes "42" as its bookmark.
+type cursorIngestor struct {
+	fakeIngestor
+}
+
+func (ingestor cursorIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *ingestors.Cursor, error) {
+	packageVersions := []data.PackageVersion{{Platform: "npm", Name: "left-pad", Version: "1.3.0"}}
+
+	return packageVersions, ingestors.NewCursor(ingestor, "42"), nil
+}
+
+// Calls onPublish, then waits until the test is over.
+type stuckPublisher struct {
+	onPublish func()
+	release   chan struct{}
+}
+
+func (publisher *stuckPublisher) Name() string { return "stuck" }
+
+func (publisher *stuckPublisher) Publish(data.PackageVersion) error {
+	publisher.onPublish()
+	<-publisher.release
+
+	return nil
+}
+
+func TestIngestAndPublish_CommitsBookmarkOnlyAfterPublishing(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(t *testing.T, depper *Depper, cancel context.CancelFunc)
+		wantErr      bool
+		wantBookmark string
+	}{
+		{
+			name:         "published",
+			setup:        func(t *testing.T, depper *Depper, cancel context.CancelFunc) {},
+			wantBookmark: "42",
+		},
+		{
+			name: "pipeline fails",
+			setup: func(t *testing.T, depper *Depper, cancel context.CancelFunc) {
+				// The dedup check can't reach Redis
+				setupTestRedis(t).Close()
+			},
+			wantErr: true,
+		},
+		{
+			name: "cancelled while publishing",
+			setup: func(t *testing.T, depper *Depper, cancel context.CancelFunc) {
+				publisher := &stuckPublisher{onPublish: cancel, release: make(chan struct{})}
+				t.Cleanup(func() { close(publisher.release) })
+				depper.pipeline.Register(publisher)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestRedis(t)
+			store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ingestors.SetBookmarkStore(store)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			depper := &Depper{pipeline: publishers.NewPipeline(), ctx: ctx, cancel: cancel}
+			test.setup(t, depper, cancel)
+
+			scheduled := newScheduledIngestor(cursorIngestor{}, config.Ingestor{})
+			err = depper.ingestAndPublish(scheduled)
+			if (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error: %v", err, test.wantErr)
+			}
+
+			bookmark, err := store.Get(context.Background(), "fake")
+			if test.wantBookmark == "" {
+				if err == nil {
+					t.Errorf("bookmark was committed as %q", bookmark)
+				}
+			} else if bookmark != test.wantBookmark {
+				t.Errorf("bookmark = %q, %v, want %q", bookmark, err, test.wantBookmark)
+			}
+		})
+	}
+}
+
+// Fails its run, either by returning err or by panicking with it.
+type failingIngestor struct {
+	fakeIngestor
+	name   string
+	err    error
+	panics bool
+}
+
+func (ingestor failingIngestor) Name() string { return ingestor.name }
+
+func (ingestor failingIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *ingestors.Cursor, error) {
+	if ingestor.panics {
+		panic(ingestor.err)
+	}
+
+	return nil, nil, ingestor.err
+}
+
+func TestIngestAndPublish_RecordsFailuresWithoutAffectingOthers(t *testing.T) {
+	tests := []struct {
+		name     string
+		ingestor failingIngestor
+		wantErr  string
+	}{
+		{
+			name:     "error",
+			ingestor: failingIngestor{name: "broken", err: errors.New("registry returned 500")},
+			wantErr:  "registry returned 500",
+		},
+		{
+			name:     "panic",
+			ingestor: failingIngestor{name: "panicky", err: errors.New("nil map"), panics: true},
+			wantErr:  "ingestor panicked: nil map",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestRedis(t)
+			store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ingestors.SetBookmarkStore(store)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			depper := &Depper{pipeline: publishers.NewPipeline(), ctx: ctx, cancel: cancel}
+
+			failing := newScheduledIngestor(test.ingestor, config.Ingestor{})
+			if err := depper.ingestAndPublish(failing); err == nil || err.Error() != test.wantErr {
+				t.Errorf("err = %v, want %q", err, test.wantErr)
+			}
+			status := failing.currentStatus()
+			if status.Running || status.LastError != test.wantErr || status.LastSuccessAt != nil {
+				t.Errorf("status = %+v, want a finished run failing with %q", status, test.wantErr)
+			}
+
+			// The failure doesn't leak into the next ingestor's run
+			healthy := newScheduledIngestor(cursorIngestor{}, config.Ingestor{})
+			if err := depper.ingestAndPublish(healthy); err != nil {
+				t.Errorf("healthy ingestor err = %v", err)
+			}
+			if status := healthy.currentStatus(); status.LastError != "" || status.LastResults != 1 {
+				t.Errorf("healthy status = %+v", status)
+			}
+			if bookmark, err := store.Get(context.Background(), "fake"); bookmark != "42" {
+				t.Errorf("bookmark = %q, %v, want %q", bookmark, err, "42")
+			}
+		})
+	}
+}
+
+func TestIngestAndPublish_SchedulesDelayedReleases(t *testing.T) {
+	server := setupTestRedis(t)
+	store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingestors.SetBookmarkStore(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	depper := &Depper{pipeline: publishers.NewPipeline(), ctx: ctx, cancel: cancel}
+	depper.pipeline.Register(publishers.NewSidekiq())
+
+	scheduled := newScheduledIngestor(cursorIngestor{}, config.Ingestor{Delay: time.Minute})
+	if err := depper.ingestAndPublish(scheduled); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Exists("queue:critical") {
+		t.Error("delayed release was enqueued straight away")
+	}
+	jobs, err := server.ZMembers("schedule")
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("scheduled jobs = %v, %v, want 1", jobs, err)
+	}
+	at, _ := server.ZScore("schedule", jobs[0])
+	if due := time.Unix(int64(at), 0); due.Before(time.Now().Add(50*time.Second)) || due.After(time.Now().Add(time.Minute)) {
+		t.Errorf("job is due at %s, want in a minute", due)
+	}
+	if !server.Exists("depper:ingest:npm:left-pad:1.3.0") {
+		t.Error("dedup key wasn't set for the scheduled job")
+	}
+}
+
+func TestScheduledIngestor_AgesTimeBookmarksUntilCommitted(t *testing.T) {
+	store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingestors.SetBookmarkStore(store)
+	ctx := context.Background()
+	stuckSince := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	tests := []struct {
+		bookmark string
+		want     time.Time
+	}{
+		{bookmark: stuckSince.Format(time.RFC3339), want: stuckSince},
+		// Sequences say nothing about when they were committed
+		{bookmark: "42"},
+	}
+
+	for _, test := range tests {
+		if err := store.Set(ctx, "fake", test.bookmark); err != nil {
+			t.Fatal(err)
+		}
+		scheduled := newScheduledIngestor(cursorIngestor{}, config.Ingestor{})
+		scheduled.loadBookmarkTime(ctx)
+		if got := scheduled.lastBookmarkCommit(); !got.Equal(test.want) {
+			t.Errorf("bookmark %q is aged from %s, want %s", test.bookmark, got, test.want)
+		}
+	}
+}
+
+func TestIngestAndPublish_RunsWithoutLeaseWhileSpooling(t *testing.T) {
+	for _, spooling := range []bool{true, false} {
+		t.Run(fmt.Sprintf("spooling=%t", spooling), func(t *testing.T) {
+			server := setupTestRedis(t)
+			store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ingestors.SetBookmarkStore(store)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			depper := &Depper{pipeline: publishers.NewPipeline(), leaser: newLeaser(time.Minute), ctx: ctx, cancel: cancel}
+			if spooling {
+				depper.spool, err = publishers.OpenSpool(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				depper.pipeline.SetSpool(depper.spool)
+			}
+
+			server.Close()
+			err = depper.ingestAndPublish(newScheduledIngestor(cursorIngestor{}, config.Ingestor{}))
+			if spooling && (err != nil || depper.spool.Size() == 0) {
+				t.Errorf("err = %v with %d bytes spooled, want the release spooled", err, depper.spool.Size())
+			}
+			if !spooling && err == nil {
+				t.Error("expected the run to fail without a lease")
+			}
+		})
+	}
+}
+
+func TestNewScheduledIngestor_Delay(t *testing.T) {
+	tests := []struct {
+		name     string
+		ingestor ingestors.PollingIngestor
+		delay    time.Duration
+		want     time.Duration
+	}{
+		{name: "own delay", ingestor: ingestors.NewNPM(), want: 2 * time.Minute},
+		{name: "configured delay", ingestor: ingestors.NewNPM(), delay: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "own delay turned off", ingestor: ingestors.NewNPM(), delay: -1, want: 0},
+		{name: "no delay", ingestor: ingestors.NewCargo(), want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduled := newScheduledIngestor(test.ingestor, config.Ingestor{Delay: test.delay})
+			if scheduled.delay != test.want {
+				t.Errorf("delay = %s, want %s", scheduled.delay, test.want)
+			}
+		})
+	}
+}
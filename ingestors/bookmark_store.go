@@ -0,0 +1,40 @@
+package ingestors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Returned by a BookmarkStore when an ingestor has never saved a bookmark
+var ErrNoBookmark = errors.New("no bookmark saved")
+
+// BookmarkStores persist each ingestor's bookmark, keyed by ingestor name,
+// so that ingestion can pick up where it left off after a restart.
+type BookmarkStore interface {
+	Get(ctx context.Context, name string) (string, error)
+	Set(ctx context.Context, name string, bookmark string) error
+	Delete(ctx context.Context, name string) error
+}
+
+var bookmarkStore BookmarkStore = &RedisBookmarkStore{}
+
+// Use to change where bookmarks are read from and written to. Defaults to Redis.
+func SetBookmarkStore(store BookmarkStore) {
+	bookmarkStore = store
+}
+
+// Build the bookmark store of the given kind ("redis", "file" or "sqlite").
+// The file and sqlite stores keep their state at path.
+func NewBookmarkStore(kind string, path string) (BookmarkStore, error) {
+	switch kind {
+	case "", "redis":
+		return &RedisBookmarkStore{}, nil
+	case "file":
+		return NewFileBookmarkStore(path)
+	case "sqlite":
+		return NewSQLiteBookmarkStore(path)
+	}
+
+	return nil, fmt.Errorf("unknown bookmark store %q", kind)
+}
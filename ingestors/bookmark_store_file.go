@@ -0,0 +1,112 @@
+package ingestors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Keeps every bookmark in a single JSON object on local disk. Handy for
+// running an ingestor locally or in a one-off job without a Redis server.
+type FileBookmarkStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileBookmarkStore(path string) (*FileBookmarkStore, error) {
+	if path == "" {
+		path = "bookmarks.json"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileBookmarkStore{path: path}, nil
+}
+
+func (store *FileBookmarkStore) Get(ctx context.Context, name string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bookmarks, err := store.read()
+	if err != nil {
+		return "", err
+	}
+	bookmark, ok := bookmarks[name]
+	if !ok {
+		return "", ErrNoBookmark
+	}
+
+	return bookmark, nil
+}
+
+func (store *FileBookmarkStore) Set(ctx context.Context, name string, bookmark string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bookmarks, err := store.read()
+	if err != nil {
+		return err
+	}
+	bookmarks[name] = bookmark
+
+	return store.write(bookmarks)
+}
+
+func (store *FileBookmarkStore) Delete(ctx context.Context, name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bookmarks, err := store.read()
+	if err != nil {
+		return err
+	}
+	delete(bookmarks, name)
+
+	return store.write(bookmarks)
+}
+
+func (store *FileBookmarkStore) read() (map[string]string, error) {
+	bookmarks := map[string]string{}
+
+	contents, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bookmarks, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, &bookmarks); err != nil {
+		return nil, err
+	}
+
+	return bookmarks, nil
+}
+
+// Write to a temporary file and rename it over the old one, so a crash never
+// leaves a half-written file behind.
+func (store *FileBookmarkStore) write(bookmarks map[string]string) error {
+	contents, err := json.MarshalIndent(bookmarks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.path)
+}
@@ -0,0 +1,31 @@
+package ingestors
+
+import (
+	"context"
+
+	"github.com/librariesio/depper/redis"
+)
+
+// Keeps bookmarks under depper:bookmark:<name> keys in the shared Redis.
+type RedisBookmarkStore struct{}
+
+func (store *RedisBookmarkStore) Get(ctx context.Context, name string) (string, error) {
+	val, err := redis.Bookmarks().Get(ctx, redisBookmarkKey(name)).Result()
+	if err == redis.Nil {
+		return "", ErrNoBookmark
+	}
+
+	return val, err
+}
+
+func (store *RedisBookmarkStore) Set(ctx context.Context, name string, bookmark string) error {
+	return redis.Bookmarks().Set(ctx, redisBookmarkKey(name), bookmark, 0).Err()
+}
+
+func (store *RedisBookmarkStore) Delete(ctx context.Context, name string) error {
+	return redis.Bookmarks().Del(ctx, redisBookmarkKey(name)).Err()
+}
+
+func redisBookmarkKey(name string) string {
+	return "depper:bookmark:" + name
+}
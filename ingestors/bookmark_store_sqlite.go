@@ -0,0 +1,72 @@
+package ingestors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// Keeps bookmarks in a table of an embedded SQLite database.
+type SQLiteBookmarkStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteBookmarkStore(path string) (*SQLiteBookmarkStore, error) {
+	if path == "" {
+		path = "bookmarks.db"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time anyway.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS bookmarks (
+		name TEXT PRIMARY KEY,
+		bookmark TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteBookmarkStore{db: db}, nil
+}
+
+func (store *SQLiteBookmarkStore) Get(ctx context.Context, name string) (string, error) {
+	var bookmark string
+
+	err := store.db.QueryRowContext(ctx, "SELECT bookmark FROM bookmarks WHERE name = ?", name).Scan(&bookmark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoBookmark
+	}
+
+	return bookmark, err
+}
+
+func (store *SQLiteBookmarkStore) Set(ctx context.Context, name string, bookmark string) error {
+	_, err := store.db.ExecContext(ctx, `INSERT INTO bookmarks (name, bookmark) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET bookmark = excluded.bookmark, updated_at = CURRENT_TIMESTAMP`, name, bookmark)
+
+	return err
+}
+
+func (store *SQLiteBookmarkStore) Delete(ctx context.Context, name string) error {
+	_, err := store.db.ExecContext(ctx, "DELETE FROM bookmarks WHERE name = ?", name)
+
+	return err
+}
+
+func (store *SQLiteBookmarkStore) Close() error {
+	return store.db.Close()
+}
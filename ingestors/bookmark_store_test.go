@@ -0,0 +1,81 @@
+package ingestors
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBookmarkStores(t *testing.T) {
+	ctx := context.Background()
+
+	for _, kind := range []string{"file", "sqlite"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "nested", "bookmarks")
+			store, err := NewBookmarkStore(kind, path)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if _, err := store.Get(ctx, "npm"); !errors.Is(err, ErrNoBookmark) {
+				t.Errorf("expected ErrNoBookmark, got %v", err)
+			}
+
+			if err := store.Set(ctx, "npm", "1234"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.Set(ctx, "npm", "5678"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.Set(ctx, "go", "2024-01-01T00:00:00Z"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// A second store on the same path sees what the first one wrote.
+			reopened, err := NewBookmarkStore(kind, path)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if bookmark, err := reopened.Get(ctx, "npm"); err != nil || bookmark != "5678" {
+				t.Errorf("expected bookmark 5678, got %q (%v)", bookmark, err)
+			}
+
+			if err := reopened.Delete(ctx, "npm"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := reopened.Get(ctx, "npm"); !errors.Is(err, ErrNoBookmark) {
+				t.Errorf("expected ErrNoBookmark after delete, got %v", err)
+			}
+			if bookmark, err := reopened.Get(ctx, "go"); err != nil || bookmark != "2024-01-01T00:00:00Z" {
+				t.Errorf("expected go bookmark to survive, got %q (%v)", bookmark, err)
+			}
+		})
+	}
+}
+
+func TestGetBookmarkDefault(t *testing.T) {
+	defer SetBookmarkStore(bookmarkStore)
+	store, err := NewFileBookmarkStore(filepath.Join(t.TempDir(), "bookmarks.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	SetBookmarkStore(store)
+
+	ingestor := NewNPM()
+	if bookmark, err := getBookmark(context.Background(), ingestor, "fallback"); err != nil || bookmark != "fallback" {
+		t.Errorf("expected default bookmark, got %q (%v)", bookmark, err)
+	}
+
+	if err := newCursor(ingestor, "42").Commit(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bookmark, err := getBookmark(context.Background(), ingestor, "fallback"); err != nil || bookmark != "42" {
+		t.Errorf("expected committed bookmark, got %q (%v)", bookmark, err)
+	}
+
+	var nilCursor *Cursor
+	if err := nilCursor.Commit(context.Background()); err != nil {
+		t.Errorf("expected committing a nil cursor to be a no-op, got %v", err)
+	}
+}
@@ -2,37 +2,73 @@ package ingestors
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
-
-	"github.com/librariesio/depper/redis"
 )
 
 // Use to set a string bookmark for an ingestor
-func setBookmark(ingestor Ingestor, bookmark string) (string, error) {
-	key := bookmarkKey(ingestor)
-
-	err := redis.Client.Set(context.Background(), key, bookmark, 0).Err()
+func setBookmark(ctx context.Context, ingestor Ingestor, bookmark string) (string, error) {
+	err := bookmarkStore.Set(ctx, ingestor.Name(), bookmark)
 	if err != nil {
-		return bookmark, fmt.Errorf("Error trying to set %s bookmark to %v - %s", key, bookmark, err)
+		return bookmark, fmt.Errorf("Error trying to set %s bookmark to %v - %s", ingestor.Name(), bookmark, err)
 	}
 
 	return bookmark, nil
 }
 
-// Use to set a datetime bookmark time for an ingestor
-func setBookmarkTime(ingestor Ingestor, bookmarkTime time.Time) (time.Time, error) {
-	if _, err := setBookmark(ingestor, bookmarkTime.Format(time.RFC3339)); err != nil {
-		return bookmarkTime, err
+// A Cursor is the bookmark an ingestor proposes at the end of a run. It
+// isn't written until Commit is called, which happens only once everything
+// from that run has been accepted by the publishing pipeline, so a crash in
+// between means re-ingesting rather than losing releases.
+type Cursor struct {
+	ingestor Ingestor
+	Value    string
+	// Applies the bookmark instead of writing it to the bookmark store, for
+	// ingestors that keep theirs in memory
+	apply func()
+}
+
+// Use to propose a string bookmark for an ingestor
+func newCursor(ingestor Ingestor, bookmark string) *Cursor {
+	return &Cursor{ingestor: ingestor, Value: bookmark}
+}
+
+// Propose a bookmark for an ingestor defined outside this package.
+func NewCursor(ingestor Ingestor, bookmark string) *Cursor {
+	return newCursor(ingestor, bookmark)
+}
+
+// Use to propose a datetime bookmark for an ingestor
+func newTimeCursor(ingestor Ingestor, bookmarkTime time.Time) *Cursor {
+	return newCursor(ingestor, bookmarkTime.Format(time.RFC3339))
+}
+
+// Use to propose a bookmark the ingestor keeps in memory. apply is called
+// on Commit, rather than anything being written to the bookmark store.
+func newMemoryCursor(ingestor Ingestor, bookmark string, apply func()) *Cursor {
+	return &Cursor{ingestor: ingestor, Value: bookmark, apply: apply}
+}
+
+// Write the proposed bookmark. A nil Cursor means the run had nothing to
+// save, so committing it is a no-op.
+func (cursor *Cursor) Commit(ctx context.Context) error {
+	if cursor == nil {
+		return nil
+	}
+	if cursor.apply != nil {
+		cursor.apply()
+		return nil
 	}
 
-	return bookmarkTime, nil
+	_, err := setBookmark(ctx, cursor.ingestor, cursor.Value)
+	return err
 }
 
 // Use to get a string bookmark for an ingestor
-func getBookmark(ingestor Ingestor, defaultValue string) (string, error) {
-	val, err := redis.Client.Get(context.Background(), bookmarkKey(ingestor)).Result()
-	if err == redis.Nil {
+func getBookmark(ctx context.Context, ingestor Ingestor, defaultValue string) (string, error) {
+	val, err := bookmarkStore.Get(ctx, ingestor.Name())
+	if errors.Is(err, ErrNoBookmark) {
 		return defaultValue, nil
 	} else if err != nil {
 		return defaultValue, err
@@ -41,14 +77,26 @@ func getBookmark(ingestor Ingestor, defaultValue string) (string, error) {
 	}
 }
 
+// Read an ingestor's committed bookmark, or ErrNoBookmark if it has none.
+func GetBookmark(ctx context.Context, ingestor Ingestor) (string, error) {
+	return bookmarkStore.Get(ctx, ingestor.Name())
+}
+
+// Overwrite an ingestor's bookmark, e.g. to re-ingest or skip part of a feed.
+func SetBookmark(ctx context.Context, ingestor Ingestor, bookmark string) error {
+	_, err := setBookmark(ctx, ingestor, bookmark)
+	return err
+}
+
+// Delete an ingestor's bookmark so its next run starts from its default.
+func ResetBookmark(ctx context.Context, ingestor Ingestor) error {
+	return bookmarkStore.Delete(ctx, ingestor.Name())
+}
+
 // Use to get a bookmark time for an ingestor
-func getBookmarkTime(ingestor Ingestor, defaultValue time.Time) (time.Time, error) {
-	result, err := getBookmark(ingestor, defaultValue.Format(time.RFC3339))
+func getBookmarkTime(ctx context.Context, ingestor Ingestor, defaultValue time.Time) (time.Time, error) {
+	result, err := getBookmark(ctx, ingestor, defaultValue.Format(time.RFC3339))
 	parsed, _ := time.Parse(time.RFC3339, result)
 
 	return parsed, err
 }
-
-func bookmarkKey(ingestor Ingestor) string {
-	return fmt.Sprintf("depper:bookmark:%s", ingestor.Name())
-}
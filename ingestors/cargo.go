@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"errors"
 	"io"
 	"time"
@@ -12,14 +13,16 @@ import (
 )
 
 const cargoSchedule = "*/5 * * * *"
-const cargoFeed = "https://crates.io/api/v1/summary"
+const cargoBaseUrl = "https://crates.io"
+const cargoSummaryPath = "/api/v1/summary"
 
 type Cargo struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewCargo() *Cargo {
-	return &Cargo{}
+	return &Cargo{Registry: Registry{BaseURL: cargoBaseUrl}}
 }
 
 func (ingestor *Cargo) Name() string {
@@ -30,19 +33,21 @@ func (ingestor *Cargo) Schedule() string {
 	return cargoSchedule
 }
 
-func (ingestor *Cargo) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(cargoFeed)
+func (ingestor *Cargo) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+cargoSummaryPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *Cargo) ingestURL(url string) []data.PackageVersion {
+func (ingestor *Cargo) ingestURL(ctx context.Context, url string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(url)
+	response, err := depperGetUrl(ctx, url)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, err
 	}
 
 	defer response.Body.Close()
@@ -69,12 +74,20 @@ func (ingestor *Cargo) ingestURL(url string) []data.PackageVersion {
 		}
 
 		if string(key) == "just_updated" || string(key) == "new_crates" {
+			action := data.ActionUpdate
+			if string(key) == "new_crates" {
+				action = data.ActionNew
+			}
 			_, subErr = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 				name, _ := jsonparser.GetString(value, "name")
 				version, _ := jsonparser.GetString(value, "newest_version")
 				createdAt, _ := jsonparser.GetString(value, "updated_at")
 				createdAtTime, _ := time.Parse(time.RFC3339, createdAt)
 				discoveryLag := time.Since(createdAtTime)
+				itemAction := action
+				if yanked, _ := jsonparser.GetBoolean(value, "yanked"); yanked {
+					itemAction = data.ActionYank
+				}
 
 				results = append(
 					results,
@@ -84,6 +97,7 @@ func (ingestor *Cargo) ingestURL(url string) []data.PackageVersion {
 						Version:      version,
 						CreatedAt:    createdAtTime,
 						DiscoveryLag: discoveryLag,
+						Action:       itemAction,
 					},
 				)
 			})
@@ -91,9 +105,5 @@ func (ingestor *Cargo) ingestURL(url string) []data.PackageVersion {
 		return subErr
 	})
 
-	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-	}
-
-	return results
+	return results, err
 }
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 )
 
 const cocoapodsSchedule = "*/5 * * * *"
-const cocoapodsReleasesUrl = "https://github.com/CocoaPods/Specs/commits.atom"
+const cocoapodsBaseUrl = "https://github.com/CocoaPods/Specs"
+const cocoapodsReleasesPath = "/commits.atom"
 
 type cocoapods struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewCocoaPods() *cocoapods {
-	return &cocoapods{}
+	return &cocoapods{Registry: Registry{BaseURL: cocoapodsBaseUrl}}
 }
 
 func (ingestor *cocoapods) Name() string {
@@ -28,19 +31,21 @@ func (ingestor *cocoapods) Schedule() string {
 	return cocoapodsSchedule
 }
 
-func (ingestor *cocoapods) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(cocoapodsReleasesUrl)
+func (ingestor *cocoapods) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+cocoapodsReleasesPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *cocoapods) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *cocoapods) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(feedUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -63,5 +68,5 @@ func (ingestor *cocoapods) ingestURL(feedUrl string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
@@ -1,14 +1,22 @@
 package ingestors
 
 import (
+	"context"
 	"time"
 
 	"github.com/librariesio/depper/data"
-	log "github.com/sirupsen/logrus"
 )
 
 const condaSchedule = "*/30 * * * *"
 
+// Repodata for the larger channels is hundreds of megabytes per architecture,
+// so give a run most of its schedule interval to finish.
+const condaTimeout = 25 * time.Minute
+
+// Repodata is served from a CDN whose mirrors can lag behind the channel,
+// so releases are looked up a few minutes after they're found.
+const condaDelay = 5 * time.Minute
+
 const (
 	CondaForge CondaRepository = "conda_forge"
 	CondaMain  CondaRepository = "conda_main"
@@ -16,13 +24,20 @@ const (
 
 type CondaRepository string
 
+var condaBaseUrls = map[CondaRepository]string{
+	CondaMain:  "https://repo.anaconda.com/pkgs/main",
+	CondaForge: "https://conda.anaconda.org/conda-forge",
+}
+
 type CondaIngestor struct {
+	Registry
 	LatestRun  time.Time
 	Repository CondaRepository
 }
 
 func NewConda(repository CondaRepository) *CondaIngestor {
 	return &CondaIngestor{
+		Registry:   Registry{BaseURL: condaBaseUrls[repository]},
 		Repository: repository,
 	}
 }
@@ -35,33 +50,33 @@ func (ingestor *CondaIngestor) Name() string {
 	return string(ingestor.Repository)
 }
 
-func (ingestor *CondaIngestor) Ingest() []data.PackageVersion {
+func (ingestor *CondaIngestor) Timeout() time.Duration {
+	return condaTimeout
+}
+
+func (ingestor *CondaIngestor) Delay() time.Duration {
+	return condaDelay
+}
+
+func (ingestor *CondaIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	// Until we save LatestRun state, we need to set a LatestRun to avoid scanning every single release in the index.
-	bookmark, err := getBookmarkTime(ingestor, time.Now().AddDate(-1, 0, 0))
+	bookmark, err := getBookmarkTime(ctx, ingestor, time.Now().AddDate(-1, 0, 0))
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return nil, nil, err
 	}
 	parser := ingestor.GetParser()
 
-	results, err := parser.GetPackages(bookmark)
+	results, err := parser.GetPackages(ctx, bookmark)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, nil, err
 	}
 	if len(results) > 0 {
-		if _, err := setBookmarkTime(ingestor, data.MaxCreatedAt(results)); err != nil {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-		}
+		return results, newTimeCursor(ingestor, data.MaxCreatedAt(results)), nil
 	}
 
-	return results
+	return results, nil, nil
 }
 
 func (ingestor *CondaIngestor) GetParser() *CondaParser {
-	url := map[CondaRepository]string{
-		CondaMain:  "https://repo.anaconda.com/pkgs/main",
-		CondaForge: "https://conda.anaconda.org/conda-forge",
-	}[ingestor.Repository]
-
-	return NewCondaParser(url, ingestor.Name())
+	return NewCondaParser(ingestor.BaseURL, ingestor.Name())
 }
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"time"
@@ -23,10 +24,10 @@ func NewCondaParser(url string, platform string) *CondaParser {
 	}
 }
 
-func (parser *CondaParser) GetPackages(lastRun time.Time) ([]data.PackageVersion, error) {
+func (parser *CondaParser) GetPackages(ctx context.Context, lastRun time.Time) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 	for _, arch := range architectures {
-		response, err := depperGetUrl(fmt.Sprintf("%s/%s/repodata.json", parser.URL, arch))
+		response, err := depperGetUrl(ctx, fmt.Sprintf("%s/%s/repodata.json", parser.URL, arch))
 		if err != nil {
 			return results, err
 		}
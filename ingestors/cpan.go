@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 )
 
 const cpanSchedule = "*/5 * * * *"
-const cpanReleasesUrl = "https://metacpan.org/recent.rss"
+const cpanBaseUrl = "https://metacpan.org"
+const cpanReleasesPath = "/recent.rss"
 
 type CPAN struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewCPAN() *CPAN {
-	return &CPAN{}
+	return &CPAN{Registry: Registry{BaseURL: cpanBaseUrl}}
 }
 
 func (ingestor *CPAN) Name() string {
@@ -28,19 +31,21 @@ func (ingestor *CPAN) Schedule() string {
 	return cpanSchedule
 }
 
-func (ingestor *CPAN) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(cpanReleasesUrl)
+func (ingestor *CPAN) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+cpanReleasesPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *CPAN) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *CPAN) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(feedUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -63,5 +68,5 @@ func (ingestor *CPAN) ingestURL(feedUrl string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
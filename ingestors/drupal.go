@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,15 +13,21 @@ import (
 )
 
 const drupalSchedule = "0 */4 * * *"
-const drupalModulesUrl = "https://www.drupal.org/project/project_module?page=%d&solrsort=ds_project_latest_release+desc"
-const drupalReleasesUrl = "https://www.drupal.org/node/%s/release/feed"
+const drupalBaseUrl = "https://www.drupal.org"
+const drupalModulesPath = "/project/project_module?page=%d&solrsort=ds_project_latest_release+desc"
+const drupalReleasesPath = "/node/%s/release/feed"
+
+// Scraping walks up to 100 listing pages plus a feed per module, which can
+// take a while.
+const drupalTimeout = 1 * time.Hour
 
 type Drupal struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewDrupal() *Drupal {
-	return &Drupal{}
+	return &Drupal{Registry: Registry{BaseURL: drupalBaseUrl}}
 }
 
 func (ingestor *Drupal) Schedule() string {
@@ -31,23 +38,29 @@ func (ingestor *Drupal) Name() string {
 	return "packagist_drupal"
 }
 
-func (ingestor *Drupal) Ingest() []data.PackageVersion {
+func (ingestor *Drupal) Timeout() time.Duration {
+	return drupalTimeout
+}
+
+func (ingestor *Drupal) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	var results []data.PackageVersion
 
-	bookmark, err := getBookmarkTime(ingestor, time.Now().AddDate(-1, 0, 0))
+	bookmark, err := getBookmarkTime(ctx, ingestor, time.Now().AddDate(-1, 0, 0))
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return nil, nil, err
 	}
 
 	page := 0
 	done := false
 	// 100 is an arbitrary limit to ensure we don't scrape all ~2k pages of packages
 	for page < 100 && !done {
-		doc, err := getHtmlDocument(fmt.Sprintf(drupalModulesUrl, page))
+		doc, err := getHtmlDocument(ctx, fmt.Sprintf(ingestor.BaseURL+drupalModulesPath, page))
 		if err != nil {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+			// Modules are sorted by latest release, so don't move the bookmark past ones we haven't seen yet.
+			return results, nil, err
 		}
 
+		var versionsErr error
 		doc.Find(".node-project-module").Each(func(i int, s *goquery.Selection) {
 			if !done {
 				var id string
@@ -59,34 +72,42 @@ func (ingestor *Drupal) Ingest() []data.PackageVersion {
 					}
 					id = parts[1]
 				}
-				packageResults := ingestor.getVersions(id, bookmark)
-				if len(packageResults) == 0 { // last page didn't have any new versions, which means we don't have to keep looking at older packages
+				packageResults, err := ingestor.getVersions(ctx, id, bookmark)
+				if err != nil {
+					versionsErr = err
+					done = true
+				} else if len(packageResults) == 0 { // last page didn't have any new versions, which means we don't have to keep looking at older packages
 					done = true
 				} else {
 					results = append(results, packageResults...)
 				}
 			}
 		})
+		if versionsErr != nil {
+			return results, nil, versionsErr
+		}
 		page++
-		time.Sleep(100 * time.Millisecond)
+
+		select {
+		case <-ctx.Done():
+			return results, nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
 	}
 
 	if len(results) > 0 {
-		if _, err := setBookmarkTime(ingestor, data.MaxCreatedAt(results)); err != nil {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-		}
+		return results, newTimeCursor(ingestor, data.MaxCreatedAt(results)), nil
 	}
 
-	return results
+	return results, nil, nil
 }
 
-func (ingestor *Drupal) getVersions(id string, bookmark time.Time) []data.PackageVersion {
+func (ingestor *Drupal) getVersions(ctx context.Context, id string, bookmark time.Time) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(fmt.Sprintf(drupalReleasesUrl, id))
+	feed, err := depperGetFeed(ctx, fmt.Sprintf(ingestor.BaseURL+drupalReleasesPath, id))
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -109,18 +130,15 @@ func (ingestor *Drupal) getVersions(id string, bookmark time.Time) []data.Packag
 		}
 	}
 
-	return results
+	return results, nil
 }
 
-func getHtmlDocument(url string) (*goquery.Document, error) {
-	res, err := depperGetUrl(url)
+func getHtmlDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	res, err := depperGetUrl(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Status code error for %s: %d %s", url, res.StatusCode, res.Status)
-	}
 
 	doc, err := goquery.NewDocumentFromReader(res.Body)
 	if err != nil {
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -12,14 +13,16 @@ import (
 )
 
 const elmSchedule = "0 */4 * * *"
-const elmFeed = "https://releases.elm.dmy.fr/.rss"
+const elmBaseUrl = "https://releases.elm.dmy.fr"
+const elmFeedPath = "/.rss"
 
 type Elm struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewElm() *Elm {
-	return &Elm{}
+	return &Elm{Registry: Registry{BaseURL: elmBaseUrl}}
 }
 
 func (ingestor *Elm) Name() string {
@@ -30,20 +33,22 @@ func (ingestor *Elm) Schedule() string {
 	return elmSchedule
 }
 
-func (ingestor *Elm) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(elmFeed)
+func (ingestor *Elm) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+elmFeedPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *Elm) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *Elm) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(feedUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 	for _, item := range feed.Items {
 		if item.PublishedParsed == nil {
@@ -66,5 +71,5 @@ func (ingestor *Elm) ingestURL(feedUrl string) []data.PackageVersion {
 				DiscoveryLag: discoveryLag,
 			})
 	}
-	return results
+	return results, nil
 }
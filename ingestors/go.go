@@ -2,25 +2,26 @@ package ingestors
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net/url"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/buger/jsonparser"
 	"github.com/librariesio/depper/data"
 )
 
 const goSchedule = "2-59/5 * * * *"
-const goIndexUrl = "https://index.golang.org/index"
+const goBaseUrl = "https://index.golang.org"
+const goIndexPath = "/index"
 
 type Go struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewGo() *Go {
-	return &Go{}
+	return &Go{Registry: Registry{BaseURL: goBaseUrl}}
 }
 
 func (ingestor *Go) Schedule() string {
@@ -31,26 +32,25 @@ func (ingestor *Go) Name() string {
 	return "go"
 }
 
-func (ingestor *Go) Ingest() []data.PackageVersion {
-	bookmarkTime, err := getBookmarkTime(ingestor, time.Now().AddDate(0, 0, -1)) // fallback to 1 day ago
+func (ingestor *Go) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	bookmarkTime, err := getBookmarkTime(ctx, ingestor, time.Now().AddDate(0, 0, -1)) // fallback to 1 day ago
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return nil, nil, err
 	}
 
 	// Currently the index only shows the last <=2000 package release from the
 	// date given. (https://proxy.golang.org/)
 	url := fmt.Sprintf(
 		"%s?since=%s&limit=2000",
-		goIndexUrl,
+		ingestor.BaseURL+goIndexPath,
 		url.QueryEscape(bookmarkTime.Format(time.RFC3339)),
 	)
 
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(url)
+	response, err := depperGetUrl(ctx, url)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, nil, err
 	}
 
 	defer response.Body.Close()
@@ -88,15 +88,13 @@ func (ingestor *Go) Ingest() []data.PackageVersion {
 		}
 	}
 
-	if _, err := setBookmarkTime(ingestor, bookmarkTime); err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-	}
-
+	// The index is in timestamp order, so whatever we managed to read is safe to bookmark.
+	cursor := newTimeCursor(ingestor, bookmarkTime)
 	if err := scanner.Err(); err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
+		return results, cursor, err
 	}
 
 	ingestor.LatestRun = time.Now()
 
-	return results
+	return results, cursor, nil
 }
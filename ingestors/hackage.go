@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 )
 
 const hackageSchedule = "*/5 * * * *"
-const hackageReleasesUrl = "https://hackage.haskell.org/packages/recent.rss"
+const hackageBaseUrl = "https://hackage.haskell.org"
+const hackageReleasesPath = "/packages/recent.rss"
 
 type Hackage struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewHackage() *Hackage {
-	return &Hackage{}
+	return &Hackage{Registry: Registry{BaseURL: hackageBaseUrl}}
 }
 
 func (ingestor *Hackage) Name() string {
@@ -28,19 +31,21 @@ func (ingestor *Hackage) Schedule() string {
 	return hackageSchedule
 }
 
-func (ingestor *Hackage) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(hackageReleasesUrl)
+func (ingestor *Hackage) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+hackageReleasesPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *Hackage) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *Hackage) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(feedUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -63,5 +68,5 @@ func (ingestor *Hackage) ingestURL(feedUrl string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -11,14 +12,16 @@ import (
 )
 
 const hexSchedule = "*/5 * * * *"
-const hexPackagesUrl = "https://hex.pm/api/packages?sort=updated_at"
+const hexBaseUrl = "https://hex.pm"
+const hexPackagesPath = "/api/packages?sort=updated_at"
 
 type Hex struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewHex() *Hex {
-	return &Hex{}
+	return &Hex{Registry: Registry{BaseURL: hexBaseUrl}}
 }
 
 func (ingestor *Hex) Name() string {
@@ -29,13 +32,12 @@ func (ingestor *Hex) Schedule() string {
 	return hexSchedule
 }
 
-func (ingestor *Hex) Ingest() []data.PackageVersion {
+func (ingestor *Hex) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(hexPackagesUrl)
+	response, err := depperGetUrl(ctx, ingestor.BaseURL+hexPackagesPath)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, nil, err
 	}
 
 	defer response.Body.Close()
@@ -66,10 +68,10 @@ func (ingestor *Hex) Ingest() []data.PackageVersion {
 		},
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
+		return results, nil, err
 	}
 
 	ingestor.LatestRun = time.Now()
 
-	return results
+	return results, nil, nil
 }
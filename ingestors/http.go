@@ -2,22 +2,28 @@ package ingestors
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/librariesio/depper/metrics"
 	"github.com/mmcdole/gofeed"
 )
 
+// Counts every request to a registry in metrics.RegistryRequests
+var registryTransport = metrics.InstrumentTransport(http.DefaultTransport)
+
 var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+	Timeout:   30 * time.Second,
+	Transport: registryTransport,
 }
 
-func depperGetUrl(url string) (*http.Response, error) {
-	return depperGetUrlWithHeaders(url, map[string]string{})
+func depperGetUrl(ctx context.Context, url string) (*http.Response, error) {
+	return depperGetUrlWithHeaders(ctx, url, map[string]string{})
 }
 
-func depperGetUrlWithHeaders(url string, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func depperGetUrlWithHeaders(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -27,15 +33,25 @@ func depperGetUrlWithHeaders(url string, headers map[string]string) (*http.Respo
 		req.Header.Set(key, value)
 	}
 
-	return httpClient.Do(req)
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+
+	return response, nil
 }
 
-func depperGetFeed(url string) (feed *gofeed.Feed, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func depperGetFeed(ctx context.Context, url string) (feed *gofeed.Feed, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	fp := gofeed.NewParser()
 	fp.UserAgent = UserAgent
+	fp.Client = httpClient
 
 	return fp.ParseURLWithContext(url, ctx)
 }
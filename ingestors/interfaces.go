@@ -1,6 +1,8 @@
 package ingestors
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/librariesio/depper/data"
@@ -12,13 +14,45 @@ type Ingestor interface {
 
 // Regular ingestors provide an API we can poll for changes. This polling
 // is done on a regular schedule.
+//
+// Ingest should stop early when ctx is cancelled. It may return the results
+// it gathered so far alongside an error; those results are still published.
+// Ingestors that keep a bookmark return the new value as a Cursor instead of
+// writing it, and it is committed once the results have been published.
 type PollingIngestor interface {
 	Ingestor
 
 	Schedule() string
-	Ingest() []data.PackageVersion
+	Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error)
 }
 
 type TTLer interface {
 	TTL() time.Duration
 }
+
+// Delayers poll registries that announce releases before their metadata is
+// consistent, and ask publishers that can schedule work to hold it back.
+type Delayer interface {
+	Delay() time.Duration
+}
+
+// Timeouters override how long a single Ingest() run may take before its
+// context is cancelled.
+type Timeouter interface {
+	Timeout() time.Duration
+}
+
+// BaseURLSetters poll a registry whose location can be overridden, e.g. to
+// point them at a mirror or a local stub.
+type BaseURLSetter interface {
+	SetBaseURL(baseURL string)
+}
+
+// Registry is embedded by ingestors to hold the base URL they poll.
+type Registry struct {
+	BaseURL string
+}
+
+func (registry *Registry) SetBaseURL(baseURL string) {
+	registry.BaseURL = strings.TrimSuffix(baseURL, "/")
+}
@@ -1,10 +1,10 @@
 package ingestors
 
 import (
+	"context"
 	"time"
 
 	"github.com/librariesio/depper/data"
-	log "github.com/sirupsen/logrus"
 )
 
 const mavenSchedule = "@every 1h"
@@ -16,13 +16,22 @@ const (
 
 type MavenRepository string
 
+var mavenBaseUrls = map[MavenRepository]string{
+	MavenCentral: "https://maven.libraries.io/mavenCentral",
+	GoogleMaven:  "https://maven.libraries.io/googleMaven",
+}
+
+const mavenRecentPath = "/recent"
+
 type MavenIngestor struct {
+	Registry
 	LatestRun  time.Time
 	Repository MavenRepository
 }
 
 func NewMaven(repository MavenRepository) *MavenIngestor {
 	return &MavenIngestor{
+		Registry:   Registry{BaseURL: mavenBaseUrls[repository]},
 		Repository: repository,
 	}
 }
@@ -31,17 +40,16 @@ func (ingestor *MavenIngestor) Schedule() string {
 	return mavenSchedule
 }
 
-func (ingestor *MavenIngestor) Ingest() []data.PackageVersion {
+func (ingestor *MavenIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	parser := ingestor.GetParser()
 
-	results, err := parser.GetPackages()
+	results, err := parser.GetPackages(ctx)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, nil, err
 	}
 
 	ingestor.LatestRun = time.Now()
-	return results
+	return results, nil, nil
 }
 
 func (ingestor *MavenIngestor) TTL() time.Duration {
@@ -53,10 +61,5 @@ func (ingestor *MavenIngestor) Name() string {
 }
 
 func (ingestor *MavenIngestor) GetParser() *MavenParser {
-	url := map[MavenRepository]string{
-		MavenCentral: "https://maven.libraries.io/mavenCentral/recent",
-		GoogleMaven:  "https://maven.libraries.io/googleMaven/recent",
-	}[ingestor.Repository]
-
-	return NewMavenParser(url, ingestor.Name())
+	return NewMavenParser(ingestor.BaseURL+mavenRecentPath, ingestor.Name())
 }
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"time"
@@ -26,10 +27,10 @@ func NewMavenParser(url string, platform string) *MavenParser {
 	}
 }
 
-func (parser *MavenParser) GetPackages() ([]data.PackageVersion, error) {
+func (parser *MavenParser) GetPackages(ctx context.Context) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(parser.URL)
+	response, err := depperGetUrl(ctx, parser.URL)
 	if err != nil {
 		return results, err
 	}
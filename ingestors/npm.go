@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -13,8 +14,8 @@ import (
 )
 
 const npmSchedule = "*/5 * * * *"
-const npmIndexUrl = "https://replicate.npmjs.com/registry"
-const npmChangesUrl = npmIndexUrl + "/_changes"
+const npmBaseUrl = "https://replicate.npmjs.com/registry"
+const npmChangesPath = "/_changes"
 
 // Current limit is 1 page per run, but if we need to do a backfill or catch up
 // we could increase this to > 1 pages.
@@ -30,11 +31,20 @@ func (ingestor *NPM) TTL() time.Duration {
 	return npmTTL
 }
 
+// The replication feed announces changes before the registry's package
+// documents are consistent, so give them a couple of minutes to catch up.
+const npmDelay = 2 * time.Minute
+
+func (ingestor *NPM) Delay() time.Duration {
+	return npmDelay
+}
+
 type NPM struct {
+	Registry
 }
 
 func NewNPM() *NPM {
-	return &NPM{}
+	return &NPM{Registry: Registry{BaseURL: npmBaseUrl}}
 }
 
 func (ingestor *NPM) Schedule() string {
@@ -45,13 +55,18 @@ func (ingestor *NPM) Name() string {
 	return "npm"
 }
 
-func (ingestor *NPM) Ingest() []data.PackageVersion {
-	currentSequence := ingestor.getCurrentSequence()
+func (ingestor *NPM) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	currentSequence, err := ingestor.getCurrentSequence(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var results []data.PackageVersion
 	for page := 0; page < pages; page++ {
-		lastSequence, lastResults := ingestor.getPage(currentSequence)
-		if len(lastResults) == 0 {
+		var lastSequence int64
+		var lastResults []data.PackageVersion
+		lastSequence, lastResults, err = ingestor.getPage(ctx, currentSequence)
+		if err != nil || len(lastResults) == 0 {
 			break
 		}
 		results = append(results, lastResults...)
@@ -61,25 +76,21 @@ func (ingestor *NPM) Ingest() []data.PackageVersion {
 		}
 	}
 
-	if _, err := setBookmark(ingestor, strconv.FormatInt(currentSequence, 10)); err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-	}
-
-	return results
+	return results, newCursor(ingestor, strconv.FormatInt(currentSequence, 10)), err
 }
 
-func (ingestor *NPM) getPage(sequence int64) (int64, []data.PackageVersion) {
+func (ingestor *NPM) getPage(ctx context.Context, sequence int64) (int64, []data.PackageVersion, error) {
 	var results []data.PackageVersion
 
 	// The header enables the new API changes and can be removed May 29th, 2025:
 	// https://github.blog/changelog/2025-02-27-changes-and-deprecation-notice-for-npm-replication-apis/
 	response, err := depperGetUrlWithHeaders(
-		fmt.Sprintf("%s?since=%d&limit=%d", npmChangesUrl, sequence, perPage),
+		ctx,
+		fmt.Sprintf("%s%s?since=%d&limit=%d", ingestor.BaseURL, npmChangesPath, sequence, perPage),
 		map[string]string{"npm-replication-opt-in": "true"},
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return sequence, results
+		return sequence, results, err
 	}
 	defer response.Body.Close()
 
@@ -88,6 +99,11 @@ func (ingestor *NPM) getPage(sequence int64) (int64, []data.PackageVersion) {
 	_, _ = jsonparser.ArrayEach(sequenceList, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 		name, _ := jsonparser.GetString(value, "id")
 		seq, _ := jsonparser.GetInt(value, "seq")
+		// Only deleted docs are marked, so other changes have no action
+		var action data.Action
+		if deleted, _ := jsonparser.GetBoolean(value, "deleted"); deleted {
+			action = data.ActionDelete
+		}
 
 		// The new NPM feed only provides names and sequences, so we don't get Version, CreatedAt or DiscoveryLag.
 		results = append(results,
@@ -95,46 +111,49 @@ func (ingestor *NPM) getPage(sequence int64) (int64, []data.PackageVersion) {
 				Platform: "npm",
 				Name:     name,
 				Sequence: strconv.FormatInt(seq, 10),
+				Action:   action,
 			})
 	})
 	lastSequence, err := jsonparser.GetInt(body, "last_seq")
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		lastSequence = sequence
+		return sequence, nil, fmt.Errorf("reading last_seq from changes feed: %w", err)
 	}
 
-	return lastSequence, results
+	return lastSequence, results, nil
 }
 
-func (ingestor *NPM) getCurrentSequence() int64 {
-	bookmark, err := getBookmark(ingestor, "")
+func (ingestor *NPM) getCurrentSequence(ctx context.Context) (int64, error) {
+	bookmark, err := getBookmark(ctx, ingestor, "")
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return 0, err
 	}
 
 	var currentSequence int64
 	if bookmark != "" {
 		currentSequence, _ = strconv.ParseInt(bookmark, 10, 64)
 	} else if currentSequence == 0 {
-		currentSequence = ingestor.getLatestSequence()
+		currentSequence, err = ingestor.getLatestSequence(ctx)
+		if err != nil {
+			return 0, err
+		}
 		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "msg": fmt.Sprintf("No NPM bookmark saved, using latest published sequence %d", currentSequence)}).Info()
 	}
 
-	return currentSequence
+	return currentSequence, nil
 }
 
 // As a fallback, fetch the latest published sequence from https://replicate.npmjs.com/registry/.
-func (ingestor *NPM) getLatestSequence() int64 {
-	response, err := depperGetUrl(npmIndexUrl)
+func (ingestor *NPM) getLatestSequence(ctx context.Context) (int64, error) {
+	response, err := depperGetUrl(ctx, ingestor.BaseURL)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return 0, err
 	}
 	defer response.Body.Close()
 	body, _ := io.ReadAll(response.Body)
 	latestSequence, err := jsonparser.GetInt(body, "update_seq")
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Fatal()
+		return 0, fmt.Errorf("reading update_seq from registry index: %w", err)
 	}
 
-	return latestSequence
+	return latestSequence, nil
 }
@@ -1,19 +1,23 @@
 package ingestors
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/librariesio/depper/data"
 )
 
 const nugetSchedule = "*/5 * * * *"
-const nugetIndexUrl = "https://api.nuget.org/v3/catalog0/index.json"
+const nugetBaseUrl = "https://api.nuget.org"
+const nugetIndexPath = "/v3/catalog0/index.json"
 const defaultLatestRun = -120 * time.Minute
 
+// Catalog items for packages that were deleted, rather than published or
+// edited
+const nugetPackageDeleteType = "nuget:PackageDelete"
+
 type nugetIndex struct {
 	IndexId string `json:"@id"`
 	Pages   []struct {
@@ -27,6 +31,7 @@ type nugetPage struct {
 	PageId   string `json:"@id"`
 	Packages []struct {
 		Url             string `json:"@id"`
+		Type            string `json:"@type"`
 		CommitTimeStamp string `json:"commitTimeStamp"`
 		CommitTime      time.Time
 		Name            string `json:"nuget:id"`
@@ -35,11 +40,12 @@ type nugetPage struct {
 }
 
 type Nuget struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewNuget() *Nuget {
-	return &Nuget{}
+	return &Nuget{Registry: Registry{BaseURL: nugetBaseUrl}}
 }
 
 func (ingestor *Nuget) Name() string {
@@ -50,31 +56,29 @@ func (ingestor *Nuget) Schedule() string {
 	return nugetSchedule
 }
 
-func (ingestor *Nuget) Ingest() []data.PackageVersion {
+func (ingestor *Nuget) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	// Until we save LatestRun state, we need to set a LatestRun to avoid scanning every single release in the index.
 	if ingestor.LatestRun.IsZero() {
 		ingestor.LatestRun = time.Now().Add(defaultLatestRun)
 	}
-	packages := ingestor.ingestURL(nugetIndexUrl)
-	ingestor.LatestRun = time.Now()
-	return packages
-}
-
-func (ingestor *Nuget) ingestURL(url string) []data.PackageVersion {
-	var results []data.PackageVersion
-
-	results, err := ingestor.getIndex(url)
+	startedAt := time.Now()
+	packages, err := ingestor.getIndex(ctx, ingestor.BaseURL+nugetIndexPath)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
+		// Leave LatestRun alone so the next run picks up the pages we missed.
+		return packages, nil, err
 	}
-
-	return results
+	// LatestRun only moves once the pipeline has accepted these, so a failed
+	// publish means the next run reads the same pages again.
+	cursor := newMemoryCursor(ingestor, startedAt.Format(time.RFC3339), func() {
+		ingestor.LatestRun = startedAt
+	})
+	return packages, cursor, nil
 }
 
-func (ingestor *Nuget) getIndex(url string) ([]data.PackageVersion, error) {
+func (ingestor *Nuget) getIndex(ctx context.Context, url string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(url)
+	response, err := depperGetUrl(ctx, url)
 	if err != nil {
 		return results, err
 	}
@@ -90,9 +94,9 @@ func (ingestor *Nuget) getIndex(url string) ([]data.PackageVersion, error) {
 	for _, page := range index.Pages {
 		page.CommitTime, _ = time.Parse(time.RFC3339, page.CommitTimeStamp)
 		if page.CommitTime.After(ingestor.LatestRun) {
-			pageResults, err := ingestor.getPage(page.Url)
+			pageResults, err := ingestor.getPage(ctx, page.Url)
 			if err != nil {
-				return results, nil
+				return results, err
 			}
 			results = append(results, pageResults...)
 		}
@@ -101,10 +105,10 @@ func (ingestor *Nuget) getIndex(url string) ([]data.PackageVersion, error) {
 	return results, nil
 }
 
-func (ingestor *Nuget) getPage(url string) ([]data.PackageVersion, error) {
+func (ingestor *Nuget) getPage(ctx context.Context, url string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(url)
+	response, err := depperGetUrl(ctx, url)
 	if err != nil {
 		return []data.PackageVersion{}, err
 	}
@@ -120,6 +124,12 @@ func (ingestor *Nuget) getPage(url string) ([]data.PackageVersion, error) {
 	for _, pkg := range page.Packages {
 		pkg.CommitTime, _ = time.Parse(time.RFC3339, pkg.CommitTimeStamp)
 		if pkg.CommitTime.After(ingestor.LatestRun) {
+			// Details items are both new releases and edits, so only deletes
+			// have a known action
+			var action data.Action
+			if pkg.Type == nugetPackageDeleteType {
+				action = data.ActionDelete
+			}
 			results = append(
 				results,
 				data.PackageVersion{
@@ -128,6 +138,7 @@ func (ingestor *Nuget) getPage(url string) ([]data.PackageVersion, error) {
 					Version:      pkg.Version,
 					CreatedAt:    pkg.CommitTime,
 					DiscoveryLag: time.Since(pkg.CommitTime),
+					Action:       action,
 				},
 			)
 		}
@@ -0,0 +1,44 @@
+package ingestors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNuget_LatestRunOnlyMovesOnCommit(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case nugetIndexPath:
+			fmt.Fprintf(w, `{"items": [{"@id": "%s/page.json", "commitTimeStamp": "2999-01-01T00:00:00Z"}]}`, server.URL)
+		case "/page.json":
+			fmt.Fprint(w, `{"items": [{"nuget:id": "Newtonsoft.Json", "nuget:version": "13.0.3", "commitTimeStamp": "2999-01-01T00:00:00Z"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ingestor := NewNuget()
+	ingestor.SetBaseURL(server.URL)
+	ctx := context.Background()
+
+	// As if the pipeline hadn't accepted the first run's releases
+	if results, cursor, err := ingestor.Ingest(ctx); err != nil || len(results) != 1 || cursor == nil {
+		t.Fatalf("first run = %v, %v, %v", results, cursor, err)
+	}
+	results, cursor, err := ingestor.Ingest(ctx)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("second run = %v, %v, want the uncommitted page read again", results, err)
+	}
+
+	uncommitted := ingestor.LatestRun
+	if err := cursor.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ingestor.LatestRun.After(uncommitted) {
+		t.Errorf("LatestRun = %s after committing, want it moved past %s", ingestor.LatestRun, uncommitted)
+	}
+}
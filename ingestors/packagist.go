@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 )
 
 const packagistSchedule = "*/5 * * * *"
-const packagistReleasesUrl = "https://packagist.org/feeds/releases.rss"
+const packagistBaseUrl = "https://packagist.org"
+const packagistReleasesPath = "/feeds/releases.rss"
 
 type Packagist struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewPackagist() *Packagist {
-	return &Packagist{}
+	return &Packagist{Registry: Registry{BaseURL: packagistBaseUrl}}
 }
 
 func (ingestor *Packagist) Name() string {
@@ -28,19 +31,21 @@ func (ingestor *Packagist) Schedule() string {
 	return packagistSchedule
 }
 
-func (ingestor *Packagist) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(packagistReleasesUrl)
+func (ingestor *Packagist) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+packagistReleasesPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *Packagist) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *Packagist) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(packagistReleasesUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -63,5 +68,5 @@ func (ingestor *Packagist) ingestURL(feedUrl string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
@@ -1,6 +1,7 @@
 package ingestors
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 )
 
 const pubSchedule = "*/5 * * * *"
-const pubReleasesUrl = "https://pub.dartlang.org/feed.atom"
+const pubBaseUrl = "https://pub.dartlang.org"
+const pubReleasesPath = "/feed.atom"
 
 type Pub struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewPub() *Pub {
-	return &Pub{}
+	return &Pub{Registry: Registry{BaseURL: pubBaseUrl}}
 }
 
 func (ingestor *Pub) Name() string {
@@ -28,19 +31,21 @@ func (ingestor *Pub) Schedule() string {
 	return pubSchedule
 }
 
-func (ingestor *Pub) Ingest() []data.PackageVersion {
-	packages := ingestor.ingestURL(pubReleasesUrl)
+func (ingestor *Pub) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	packages, err := ingestor.ingestURL(ctx, ingestor.BaseURL+pubReleasesPath)
+	if err != nil {
+		return packages, nil, err
+	}
 	ingestor.LatestRun = time.Now()
-	return packages
+	return packages, nil, nil
 }
 
-func (ingestor *Pub) ingestURL(feedUrl string) []data.PackageVersion {
+func (ingestor *Pub) ingestURL(ctx context.Context, feedUrl string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(feedUrl)
+	feed, err := depperGetFeed(ctx, feedUrl)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -64,5 +69,5 @@ func (ingestor *Pub) ingestURL(feedUrl string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
@@ -13,6 +13,8 @@ and found releases are returned as ingestion events.
 */
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -23,16 +25,18 @@ import (
 	"github.com/mmcdole/gofeed"
 )
 
-const pyPiUpdatesFeedUrl = "https://pypi.org/rss/updates.xml"
-const pyPiPackagesFeedUrl = "https://pypi.org/rss/packages.xml"
-const pyPiReleasesFeedUrl = "https://pypi.org/rss/project/%s/releases.xml"
+const pyPiBaseUrl = "https://pypi.org"
+const pyPiUpdatesFeedPath = "/rss/updates.xml"
+const pyPiPackagesFeedPath = "/rss/packages.xml"
+const pyPiReleasesFeedPath = "/rss/project/%s/releases.xml"
 
 type PyPiRss struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewPyPiRss() *PyPiRss {
-	return &PyPiRss{}
+	return &PyPiRss{Registry: Registry{BaseURL: pyPiBaseUrl}}
 }
 
 func (ingestor *PyPiRss) Name() string {
@@ -43,14 +47,17 @@ func (ingestor *PyPiRss) Schedule() string {
 	return "* * * * *"
 }
 
-func (ingestor *PyPiRss) Ingest() []data.PackageVersion {
-	packages := append(
-		ingestor.getUpdates(),
-		ingestor.getNewPackages()...,
-	)
+func (ingestor *PyPiRss) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	updates, updatesErr := ingestor.getUpdates(ctx)
+	newPackages, cursor, newPackagesErr := ingestor.getNewPackages(ctx)
+	packages := append(updates, newPackages...)
+
+	if err := errors.Join(updatesErr, newPackagesErr); err != nil {
+		return packages, cursor, err
+	}
 	ingestor.LatestRun = time.Now()
 
-	return packages
+	return packages, cursor, nil
 }
 
 func createUpdateItemPackageVersion(item *gofeed.Item) data.PackageVersion {
@@ -66,13 +73,12 @@ func createUpdateItemPackageVersion(item *gofeed.Item) data.PackageVersion {
 }
 
 // Retrieve the latest release updates
-func (ingestor *PyPiRss) getUpdates() []data.PackageVersion {
+func (ingestor *PyPiRss) getUpdates(ctx context.Context) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(pyPiUpdatesFeedUrl)
+	feed, err := depperGetFeed(ctx, ingestor.BaseURL+pyPiUpdatesFeedPath)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -87,23 +93,22 @@ func (ingestor *PyPiRss) getUpdates() []data.PackageVersion {
 		results = append(results, createUpdateItemPackageVersion(item))
 	}
 
-	return results
+	return results, nil
 }
 
 // Retrieve the latest new PyPI packages
-func (ingestor *PyPiRss) getNewPackages() []data.PackageVersion {
+func (ingestor *PyPiRss) getNewPackages(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	var results []data.PackageVersion
 
 	// Get the current bookmark
-	bookmark, err := getBookmarkTime(ingestor, time.Now().AddDate(-1, 0, 0))
+	bookmark, err := getBookmarkTime(ctx, ingestor, time.Now().AddDate(-1, 0, 0))
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
+		return results, nil, err
 	}
 
-	feed, err := depperGetFeed(pyPiPackagesFeedUrl)
+	feed, err := depperGetFeed(ctx, ingestor.BaseURL+pyPiPackagesFeedPath)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, nil, err
 	}
 
 	// Get releases for items not yet seen
@@ -123,25 +128,27 @@ func (ingestor *PyPiRss) getNewPackages() []data.PackageVersion {
 		}
 		packageName := linkBits[len(linkBits)-2]
 
-		results = append(results, ingestor.getReleases(packageName)...)
+		releases, err := ingestor.getReleases(ctx, packageName)
+		if err != nil {
+			// Don't move the bookmark past a package whose releases we couldn't fetch.
+			return results, nil, err
+		}
+		results = append(results, releases...)
 	}
 
 	if len(results) > 0 {
-		if _, err := setBookmarkTime(ingestor, data.MaxCreatedAt(results)); err != nil {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-		}
+		return results, newTimeCursor(ingestor, data.MaxCreatedAt(results)), nil
 	}
 
-	return results
+	return results, nil, nil
 }
 
-func (ingestor *PyPiRss) getReleases(packageName string) []data.PackageVersion {
+func (ingestor *PyPiRss) getReleases(ctx context.Context, packageName string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	feed, err := depperGetFeed(fmt.Sprintf(pyPiReleasesFeedUrl, packageName))
+	feed, err := depperGetFeed(ctx, fmt.Sprintf(ingestor.BaseURL+pyPiReleasesFeedPath, packageName))
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-		return results
+		return results, err
 	}
 
 	for _, item := range feed.Items {
@@ -159,5 +166,5 @@ func (ingestor *PyPiRss) getReleases(packageName string) []data.PackageVersion {
 			})
 	}
 
-	return results
+	return results, nil
 }
@@ -22,6 +22,7 @@ Once we see one of these actions, we create an ingestion event for the release.
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -34,14 +35,15 @@ import (
 	"github.com/kolo/xmlrpc"
 )
 
-const pyPiRpcServer = "https://pypi.org/pypi"
+const pyPiRpcPath = "/pypi"
 
 type PyPiXmlRpc struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewPyPiXmlRpc() *PyPiXmlRpc {
-	return &PyPiXmlRpc{}
+	return &PyPiXmlRpc{Registry: Registry{BaseURL: pyPiBaseUrl}}
 }
 
 func (ingestor *PyPiXmlRpc) Name() string {
@@ -71,6 +73,22 @@ func (response *PyPiXmlRpcResponse) IsIngestionAction() bool {
 	return false
 }
 
+// What happened to the release, for the ingestion actions
+func (response *PyPiXmlRpcResponse) PackageAction() data.Action {
+	switch response.Action {
+	case "new release":
+		return data.ActionNew
+	case "yank release":
+		return data.ActionYank
+	case "unyank release":
+		return data.ActionUnyank
+	case "remove release":
+		return data.ActionDelete
+	}
+
+	return ""
+}
+
 // Get the PackageVersion struct for this response
 func (response *PyPiXmlRpcResponse) GetPackageVersion() data.PackageVersion {
 	createdAt := time.Unix(response.Timestamp, 0)
@@ -82,6 +100,7 @@ func (response *PyPiXmlRpcResponse) GetPackageVersion() data.PackageVersion {
 		Version:      response.Version,
 		CreatedAt:    createdAt,
 		DiscoveryLag: discoveryLag,
+		Action:       response.PackageAction(),
 	}
 }
 
@@ -118,7 +137,7 @@ func createResponseStruct(log []any) (*PyPiXmlRpcResponse, error) {
 // are UTC values. The argument is a UTC integer seconds since the epoch (e.g., the timestamp method
 // to a datetime.datetime object).
 // calls "changelog(since, with_ids=False)" RPC
-func (ingestor *PyPiXmlRpc) Ingest() []data.PackageVersion {
+func (ingestor *PyPiXmlRpc) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
 	// An array of interface arrays. Each log entry contains:
 	// * name(string), version(string), timestamp(int64), action(string), serial(int)
 	// These are converted to PyPiXmlRpcResponse structs
@@ -126,9 +145,9 @@ func (ingestor *PyPiXmlRpc) Ingest() []data.PackageVersion {
 	var results []data.PackageVersion
 
 	// Get the current bookmark
-	bookmark, err := getBookmark(ingestor, "")
+	bookmark, err := getBookmark(ctx, ingestor, "")
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
+		return nil, nil, err
 	}
 
 	// Bookmark type migration: the old bookmarks were ISO8601 timestamps, which were 25 chars longs,
@@ -144,17 +163,23 @@ func (ingestor *PyPiXmlRpc) Ingest() []data.PackageVersion {
 		}
 	}
 
-	client, _ := xmlrpc.NewClient(pyPiRpcServer, nil)
+	client, err := xmlrpc.NewClient(ingestor.BaseURL+pyPiRpcPath, registryTransport)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer client.Close()
 
 	if serial == 0 {
 		serial, err = getLastSerial(client)
 		if err != nil {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Error(err)
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info("Couldn't fetch last serial")
-		} else {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info("Fetched default serial: ", serial)
+			return nil, nil, fmt.Errorf("couldn't fetch last serial: %w", err)
 		}
+		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info("Fetched default serial: ", serial)
+	}
+
+	// The xmlrpc client doesn't take a context, so check for cancellation before the slow call.
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
 
 	err = client.Call("changelog_since_serial", serial, &response)
@@ -165,7 +190,7 @@ func (ingestor *PyPiXmlRpc) Ingest() []data.PackageVersion {
 			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info(fmt.Sprintf("Skipping page from serial %d", serial))
 			response = [][]any{}
 		} else {
-			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
+			return nil, nil, err
 		}
 	}
 
@@ -184,11 +209,7 @@ func (ingestor *PyPiXmlRpc) Ingest() []data.PackageVersion {
 		}
 	}
 
-	if _, err := setBookmark(ingestor, strconv.Itoa(int(serial))); err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Fatal(err)
-	}
-
-	return results
+	return results, newCursor(ingestor, strconv.Itoa(int(serial))), nil
 }
 
 // Serials for events from pypa are ints (e.g. 20972215).
@@ -3,19 +3,22 @@ package ingestors
 import (
 	"testing"
 	"time"
+
+	"github.com/librariesio/depper/data"
 )
 
 type ingestionTest struct {
-	action   string
-	expected bool
+	action         string
+	expected       bool
+	expectedAction data.Action
 }
 
 var ingestionTests = []ingestionTest{
-	{"new release", true},
-	{"yank release", true},
-	{"unyank release", true},
-	{"remove release", true},
-	{"something else", false},
+	{"new release", true, data.ActionNew},
+	{"yank release", true, data.ActionYank},
+	{"unyank release", true, data.ActionUnyank},
+	{"remove release", true, data.ActionDelete},
+	{"something else", false, ""},
 }
 
 func TestPyPiXmlRpcResponse_IsIngestionAction(t *testing.T) {
@@ -27,6 +30,9 @@ func TestPyPiXmlRpcResponse_IsIngestionAction(t *testing.T) {
 		if response.IsIngestionAction() != test.expected {
 			t.Errorf("for %s, got %t, wanted %t", test.action, response.IsIngestionAction(), test.expected)
 		}
+		if action := response.GetPackageVersion().Action; action != test.expectedAction {
+			t.Errorf("for %s, got action %q, wanted %q", test.action, action, test.expectedAction)
+		}
 	}
 }
 
@@ -0,0 +1,169 @@
+package ingestors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librariesio/depper/data"
+)
+
+// Points every ingestor at a local registry that answers each request with
+// status, and checks that a failing registry or a cancelled ctx surfaces as
+// an error from Ingest rather than an empty, successful run.
+func TestIngest_RegistryFailures(t *testing.T) {
+	store, err := NewFileBookmarkStore(filepath.Join(t.TempDir(), "bookmarks"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	SetBookmarkStore(store)
+	t.Cleanup(func() { SetBookmarkStore(&RedisBookmarkStore{}) })
+
+	ingestors := []PollingIngestor{
+		NewCargo(),
+		NewCocoaPods(),
+		NewConda(CondaForge),
+		NewCPAN(),
+		NewDrupal(),
+		NewElm(),
+		NewGo(),
+		NewHackage(),
+		NewHex(),
+		NewMaven(MavenCentral),
+		NewNPM(),
+		NewNuget(),
+		NewPackagist(),
+		NewPub(),
+		NewPyPiRss(),
+		NewPyPiXmlRpc(),
+		NewRubyGems(),
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		cancel bool
+	}{
+		{name: "server error", status: http.StatusInternalServerError},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "cancelled", status: http.StatusOK, cancel: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			for _, ingestor := range ingestors {
+				t.Run(ingestor.Name(), func(t *testing.T) {
+					ingestor.(BaseURLSetter).SetBaseURL(server.URL)
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					if test.cancel {
+						cancel()
+					}
+
+					results, cursor, err := ingestor.Ingest(ctx)
+					if err == nil {
+						t.Errorf("expected an error, got %d results", len(results))
+					}
+					if cursor != nil {
+						t.Errorf("expected no cursor after a failed run, got %v", cursor)
+					}
+				})
+			}
+		})
+	}
+}
+
+// Points ingestors at a local registry serving releases alongside yanks and
+// removals, and checks each is reported with its action.
+func TestIngest_Actions(t *testing.T) {
+	store, err := NewFileBookmarkStore(filepath.Join(t.TempDir(), "bookmarks"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	SetBookmarkStore(store)
+	t.Cleanup(func() { SetBookmarkStore(&RedisBookmarkStore{}) })
+
+	tests := []struct {
+		ingestor  PollingIngestor
+		bookmark  string
+		responses map[string]string
+		want      []data.Action
+	}{
+		{
+			ingestor: NewNPM(),
+			bookmark: "1",
+			responses: map[string]string{
+				"/_changes": `{"results": [{"seq": 2, "id": "left-pad"}, {"seq": 3, "id": "right-pad", "deleted": true}], "last_seq": 3}`,
+			},
+			want: []data.Action{"", data.ActionDelete},
+		},
+		{
+			ingestor: NewNuget(),
+			responses: map[string]string{
+				"/v3/catalog0/index.json": `{"items": [{"@id": "{server}/page.json", "commitTimeStamp": "2999-01-01T00:00:00Z"}]}`,
+				"/page.json": `{"items": [
+					{"@type": "nuget:PackageDetails", "nuget:id": "Newtonsoft.Json", "nuget:version": "13.0.3", "commitTimeStamp": "2999-01-01T00:00:00Z"},
+					{"@type": "nuget:PackageDelete", "nuget:id": "Leftpad", "nuget:version": "1.0.0", "commitTimeStamp": "2999-01-01T00:00:00Z"}
+				]}`,
+			},
+			want: []data.Action{"", data.ActionDelete},
+		},
+		{
+			ingestor: NewCargo(),
+			responses: map[string]string{
+				"/api/v1/summary": `{
+					"new_crates": [{"name": "fresh", "newest_version": "0.1.0", "updated_at": "2024-05-06T07:08:09Z"}],
+					"just_updated": [
+						{"name": "serde", "newest_version": "1.0.200", "updated_at": "2024-05-06T07:08:09Z"},
+						{"name": "broken", "newest_version": "0.2.0", "updated_at": "2024-05-06T07:08:09Z", "yanked": true}
+					]
+				}`,
+			},
+			want: []data.Action{data.ActionNew, data.ActionUpdate, data.ActionYank},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ingestor.Name(), func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response, ok := test.responses[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, strings.ReplaceAll(response, "{server}", server.URL))
+			}))
+			defer server.Close()
+			test.ingestor.(BaseURLSetter).SetBaseURL(server.URL)
+			if test.bookmark != "" {
+				if err := store.Set(context.Background(), test.ingestor.Name(), test.bookmark); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			results, _, err := test.ingestor.Ingest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(test.want) {
+				t.Fatalf("got %+v, want %d releases", results, len(test.want))
+			}
+			for i, action := range test.want {
+				if results[i].Action != action {
+					t.Errorf("release %d has action %q, want %q", i, results[i].Action, action)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package ingestors
 
 import (
+	"context"
+	"errors"
 	"io"
 	"time"
 
@@ -11,15 +13,17 @@ import (
 )
 
 const rubyGemsSchedule = "*/5 * * * *"
-const rubyGemsJustUpdatedURL = "https://rubygems.org/api/v1/activity/just_updated.json"
-const rubyGemsLatestURL = "https://rubygems.org/api/v1/activity/latest.json"
+const rubyGemsBaseUrl = "https://rubygems.org"
+const rubyGemsJustUpdatedPath = "/api/v1/activity/just_updated.json"
+const rubyGemsLatestPath = "/api/v1/activity/latest.json"
 
 type RubyGems struct {
+	Registry
 	LatestRun time.Time
 }
 
 func NewRubyGems() *RubyGems {
-	return &RubyGems{}
+	return &RubyGems{Registry: Registry{BaseURL: rubyGemsBaseUrl}}
 }
 
 func (ingestor *RubyGems) Name() string {
@@ -30,24 +34,26 @@ func (ingestor *RubyGems) Schedule() string {
 	return rubyGemsSchedule
 }
 
-func (ingestor *RubyGems) Ingest() []data.PackageVersion {
-	results := append(
-		ingestor.ingestURL(rubyGemsJustUpdatedURL),
-		ingestor.ingestURL(rubyGemsLatestURL)...,
-	)
+func (ingestor *RubyGems) Ingest(ctx context.Context) ([]data.PackageVersion, *Cursor, error) {
+	justUpdated, justUpdatedErr := ingestor.ingestURL(ctx, ingestor.BaseURL+rubyGemsJustUpdatedPath)
+	latest, latestErr := ingestor.ingestURL(ctx, ingestor.BaseURL+rubyGemsLatestPath)
+	results := append(justUpdated, latest...)
+
+	if err := errors.Join(justUpdatedErr, latestErr); err != nil {
+		return results, nil, err
+	}
 
 	ingestor.LatestRun = time.Now()
 
-	return results
+	return results, nil, nil
 }
 
-func (ingestor *RubyGems) ingestURL(url string) []data.PackageVersion {
+func (ingestor *RubyGems) ingestURL(ctx context.Context, url string) ([]data.PackageVersion, error) {
 	var results []data.PackageVersion
 
-	response, err := depperGetUrl(url)
+	response, err := depperGetUrl(ctx, url)
 	if err != nil {
-		log.WithFields(log.Fields{"ingestor": ingestor.Name(), "error": err}).Error()
-		return results
+		return results, err
 	}
 
 	defer response.Body.Close()
@@ -84,5 +90,5 @@ func (ingestor *RubyGems) ingestURL(url string) []data.PackageVersion {
 			})
 	})
 
-	return results
+	return results, nil
 }
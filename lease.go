@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/librariesio/depper/redis"
+
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// How long a lease outlives a replica that dies mid-run, unless configured
+const defaultLeaseTTL = 30 * time.Second
+
+// Only touch the lease if this replica still holds it.
+var renewLeaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseLeaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Hands out Redis leases so that, across every Depper replica, each ingestor
+// has at most one run in progress. A lease is renewed while its run goes on
+// and expires after ttl if the replica holding it dies, so another replica
+// picks the ingestor up on its next tick.
+type leaser struct {
+	// Identifies this replica in the lease keys
+	owner string
+	ttl   time.Duration
+}
+
+func newLeaser(ttl time.Duration) *leaser {
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	hostname, _ := os.Hostname()
+
+	return &leaser{
+		owner: fmt.Sprintf("%s:%d:%x", hostname, os.Getpid(), rand.Int63()),
+		ttl:   ttl,
+	}
+}
+
+type lease struct {
+	leaser *leaser
+	key    string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Take the lease for the named ingestor and keep renewing it until release
+// is called. Returns nil if another replica holds it. If the lease is lost,
+// either because another replica holds it or because it couldn't be renewed
+// and is about to expire, lost is called so the run can stop.
+func (leaser *leaser) acquire(ctx context.Context, name string, lost func()) (*lease, error) {
+	key := "depper:lease:" + name
+	// The key expires no sooner than a ttl after the command was sent
+	sentAt := time.Now()
+	acquired, err := redis.Client.SetNX(ctx, key, leaser.owner, leaser.ttl).Result()
+	if err != nil || !acquired {
+		return nil, err
+	}
+
+	lease := &lease{
+		leaser: leaser,
+		key:    key,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	lease.keep(sentAt, lost)
+
+	return lease, nil
+}
+
+// Renew the lease every third of its ttl. One that can't be renewed is given
+// up a third of its ttl before it could expire, so no other replica can take
+// it while this one still runs, and each renewal times out well within that.
+func (lease *lease) keep(sentAt time.Time, lost func()) {
+	interval := lease.leaser.ttl / 3
+	giveUpAfter := lease.leaser.ttl - interval
+	renewTimeout := interval / 2
+
+	go func() {
+		defer close(lease.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-lease.stop:
+				return
+			case <-ticker.C:
+				renewSentAt := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), renewTimeout)
+				renewed, err := renewLeaseScript.Run(ctx, redis.Client, []string{lease.key}, lease.leaser.owner, lease.leaser.ttl.Milliseconds()).Int()
+				cancel()
+				if err != nil {
+					log.WithFields(log.Fields{"lease": lease.key, "error": err}).Warn("couldn't renew lease")
+					if time.Since(sentAt) >= giveUpAfter {
+						log.WithFields(log.Fields{"lease": lease.key}).Error("lease is about to expire, giving it up")
+						lost()
+						return
+					}
+					continue
+				}
+				if renewed == 0 {
+					log.WithFields(log.Fields{"lease": lease.key}).Error("lost lease")
+					lost()
+					return
+				}
+				sentAt = renewSentAt
+			}
+		}
+	}()
+}
+
+// Stop renewing and give the lease up so any replica can take it.
+func (lease *lease) release() {
+	close(lease.stop)
+	<-lease.done
+
+	if err := releaseLeaseScript.Run(context.Background(), redis.Client, []string{lease.key}, lease.leaser.owner).Err(); err != nil {
+		log.WithFields(log.Fields{"lease": lease.key, "error": err}).Warn("couldn't release lease, it will expire")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/redis"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+func setupTestRedis(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	redis.Client = goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redis.Client.Close() })
+
+	return server
+}
+
+func TestLeaser_OneHolderAtATime(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	first, second := newLeaser(time.Minute), newLeaser(time.Minute)
+
+	lease, err := first.acquire(ctx, "npm", func() {})
+	if err != nil || lease == nil {
+		t.Fatalf("first acquire = %v, %v", lease, err)
+	}
+
+	if held, err := second.acquire(ctx, "npm", func() {}); err != nil || held != nil {
+		t.Fatalf("second acquire while held = %v, %v", held, err)
+	}
+	if other, err := second.acquire(ctx, "pypi", func() {}); err != nil || other == nil {
+		t.Fatalf("acquire of another ingestor = %v, %v", other, err)
+	} else {
+		other.release()
+	}
+
+	lease.release()
+	if taken, err := second.acquire(ctx, "npm", func() {}); err != nil || taken == nil {
+		t.Fatalf("second acquire after release = %v, %v", taken, err)
+	} else {
+		taken.release()
+	}
+}
+
+func TestLeaser_FailsOverWhenHolderDies(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	dead, alive := newLeaser(time.Minute), newLeaser(time.Minute)
+
+	// Never released, as if the replica had died mid-run
+	if lease, err := dead.acquire(ctx, "npm", func() {}); err != nil || lease == nil {
+		t.Fatalf("acquire = %v, %v", lease, err)
+	}
+
+	server.FastForward(time.Minute)
+	if lease, err := alive.acquire(ctx, "npm", func() {}); err != nil || lease == nil {
+		t.Fatalf("acquire after expiry = %v, %v", lease, err)
+	}
+}
+
+func TestLeaser_LostLease(t *testing.T) {
+	server := setupTestRedis(t)
+	leaser := newLeaser(30 * time.Millisecond)
+
+	lost := make(chan struct{})
+	lease, err := leaser.acquire(context.Background(), "npm", func() { close(lost) })
+	if err != nil || lease == nil {
+		t.Fatalf("acquire = %v, %v", lease, err)
+	}
+	defer lease.release()
+
+	server.Set("depper:lease:npm", "another replica")
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("expected the lease to be reported lost")
+	}
+
+	if got, _ := server.Get("depper:lease:npm"); got != "another replica" {
+		t.Errorf("releasing a lost lease changed it to %q", got)
+	}
+}
+
+func TestIngestAndPublish_SkipsWhileRunning(t *testing.T) {
+	depper := &Depper{ctx: context.Background()}
+	scheduled := newScheduledIngestor(fakeIngestor{}, config.Ingestor{})
+
+	scheduled.running.Lock()
+	defer scheduled.running.Unlock()
+
+	if err := depper.ingestAndPublish(scheduled); !errors.Is(err, errRunInProgress) {
+		t.Errorf("err = %v, want errRunInProgress", err)
+	}
+	if scheduled.currentStatus().LastStartedAt != nil {
+		t.Error("a skipped run shouldn't be recorded")
+	}
+}
+
+// Forwards connections to addr until stalled, after which Redis's replies
+// are swallowed, as if it had stopped answering.
+func stallingProxy(t *testing.T, addr string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var stalled atomic.Bool
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			t.Cleanup(func() { conn.Close(); upstream.Close() })
+			go io.Copy(upstream, conn)
+			go func() {
+				buf := make([]byte, 4096)
+				for {
+					n, err := upstream.Read(buf)
+					if err != nil {
+						return
+					}
+					if !stalled.Load() {
+						conn.Write(buf[:n])
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), func() { stalled.Store(true) }
+}
+
+func TestLeaser_LostBeforeExpiryWhenRedisIsUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		// Makes Redis unreachable once the lease is held
+		setup func(t *testing.T, server *miniredis.Miniredis) func()
+	}{
+		{
+			name: "down",
+			setup: func(t *testing.T, server *miniredis.Miniredis) func() {
+				return server.Close
+			},
+		},
+		{
+			// Only the renewal's own timeout stops it waiting
+			name: "not answering",
+			setup: func(t *testing.T, server *miniredis.Miniredis) func() {
+				addr, stall := stallingProxy(t, server.Addr())
+				redis.Client.Close()
+				redis.Client = goredis.NewClient(&goredis.Options{Addr: addr, MaxRetries: -1, ReadTimeout: time.Second})
+				return stall
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unreachable := test.setup(t, setupTestRedis(t))
+			ttl := 300 * time.Millisecond
+			leaser := newLeaser(ttl)
+
+			lost := make(chan struct{})
+			acquiredAt := time.Now()
+			lease, err := leaser.acquire(context.Background(), "npm", func() { close(lost) })
+			if err != nil || lease == nil {
+				t.Fatalf("acquire = %v, %v", lease, err)
+			}
+			defer lease.release()
+
+			unreachable()
+			select {
+			case <-lost:
+				if elapsed := time.Since(acquiredAt); elapsed >= ttl {
+					t.Errorf("lease was given up after %s, once it could have expired", elapsed)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("expected the lease to be reported lost before it could expire")
+			}
+		})
+	}
+}
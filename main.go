@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
-	"time"
 
+	"github.com/librariesio/depper/config"
 	"github.com/librariesio/depper/ingestors"
 	"github.com/librariesio/depper/publishers"
 	"github.com/librariesio/depper/redis"
@@ -17,16 +22,27 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
-const defaultTTL = 24 * time.Hour
-
 type Depper struct {
 	// Place onto which jobs are placed for Libraries.io to further examine a package manager's package
-	pipeline      *publishers.Pipeline
+	pipeline   *publishers.Pipeline
+	bookmarks  ingestors.BookmarkStore
+	scheduled  []*scheduledIngestor
+	adminToken string
+	// Nil unless leases are enabled
+	leaser *leaser
+	// Nil unless a spool is configured
+	spool         *publishers.Spool
 	signalHandler chan os.Signal
+	// Cancelled on shutdown so in-flight ingestor runs stop early
+	ctx    context.Context
+	cancel context.CancelFunc
+	// In-flight runs, which shutdown waits for
+	runs     sync.WaitGroup
+	mu       sync.Mutex
+	stopping bool
 }
 
 func waitForExitSignal(signalHandler chan os.Signal) os.Signal {
-	signal.Notify(signalHandler, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-signalHandler
 	signal.Stop(signalHandler)
 
@@ -34,6 +50,44 @@ func waitForExitSignal(signalHandler chan os.Signal) os.Signal {
 }
 
 func main() {
+	setupLogger()
+
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "run":
+		err = runCommand(args)
+	case "ingest":
+		err = ingestCommand(args)
+	case "bookmark":
+		err = bookmarkCommand(args)
+	case "publish":
+		err = publishCommand(args)
+	case "dead-letters":
+		err = deadLettersCommand(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		err = fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Start every enabled ingestor on its schedule and block until we're told to exit.
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the config file")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
 	defer func() {
 		// This defer will run when SIGINT is caught, but not for SIGKILL/SIGTERM/SIGHUP/SIGSTOP or os.Exit().
 		log.Info("Stopping Depper")
@@ -49,90 +103,262 @@ func main() {
 		defer tracer.Stop()
 	}
 
-	setupLogger()
-	redis.Connect()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+	if err := validateIngestorConfigs(cfg.Ingestors); err != nil {
+		return err
+	}
+
+	if err := connectRedis(cfg); err != nil {
+		// Spool only while Redis is unreachable, not when it's misconfigured
+		if cfg.Spool.Path == "" || redis.Client == nil {
+			return err
+		}
+		log.WithFields(log.Fields{"error": err}).Warn("redis is unreachable, spooling releases until it is back")
+	}
+
+	bookmarkStore, err := ingestors.NewBookmarkStore(cfg.Bookmarks.Store, cfg.Bookmarks.Path)
+	if err != nil {
+		return err
+	}
+	ingestors.SetBookmarkStore(bookmarkStore)
+
+	pipeline, err := createPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	spool, err := openSpool(cfg, pipeline)
+	if err != nil {
+		return err
+	}
 
 	log.Info("Starting Depper")
+	ctx, cancel := context.WithCancel(context.Background())
 	depper := &Depper{
-		pipeline:      createPipeline(),
+		pipeline:      pipeline,
+		bookmarks:     bookmarkStore,
+		adminToken:    cfg.HTTP.AdminToken,
+		spool:         spool,
 		signalHandler: make(chan os.Signal, 1),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	signal.Notify(depper.signalHandler, syscall.SIGINT, syscall.SIGTERM)
+	if cfg.Lease.IsEnabled() {
+		depper.leaser = newLeaser(cfg.Lease.TTL)
 	}
-	depper.registerIngestors()
+	depper.registerMetrics()
+	server := depper.startServer(cfg.HTTP.Addr)
+	if spool != nil {
+		go pipeline.DrainSpool(depper.ctx, cfg.Spool.DrainInterval)
+	}
+
+	// Registering runs each ingestor once, which takes a while, so do it in
+	// the background and still shut down cleanly if a signal arrives first.
+	go depper.registerIngestors(cfg.Ingestors)
 
 	sig := waitForExitSignal(depper.signalHandler)
+	log.WithFields(log.Fields{"signal": sig}).Info("Shutting down")
+	depper.shutdown(server, cfg.Shutdown)
+
+	log.Info("Exiting")
+	return nil
+}
+
+// Open the configured spool, if any, and let the pipeline spool to it.
+func openSpool(cfg *config.Config, pipeline *publishers.Pipeline) (*publishers.Spool, error) {
+	if cfg.Spool.Path == "" {
+		return nil, nil
+	}
+	spool, err := publishers.OpenSpool(cfg.Spool.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening spool: %w", err)
+	}
+	pipeline.SetSpool(spool)
 
-	log.WithFields(log.Fields{"signal": sig}).Info("Exiting")
+	return spool, nil
 }
 
-func createPipeline() *publishers.Pipeline {
+func connectRedis(cfg *config.Config) error {
+	return redis.Connect(redis.URLs{
+		Default:   cfg.Redis.URL,
+		Bookmarks: cfg.Redis.BookmarksURL,
+		Dedup:     cfg.Redis.DedupURL,
+		Queue:     cfg.Redis.QueueURL,
+	})
+}
+
+func createPipeline(cfg *config.Config) (*publishers.Pipeline, error) {
 	pipeline := publishers.NewPipeline()
-	pipeline.Register(&publishers.LoggingPublisher{})
-	pipeline.Register(publishers.NewSidekiq())
-	return pipeline
+	pipeline.SetBatchSize(cfg.Pipeline.BatchSize)
+	pipeline.SetDedupCache(cfg.Pipeline.DedupCacheSize, cfg.Pipeline.DedupCacheMaxTTL)
+	for _, publisherConfig := range cfg.Publishers {
+		publisher, err := createPublisher(publisherConfig)
+		if err != nil {
+			return nil, err
+		}
+		pool := publisherConfig.Pool
+		if pool.Workers == 0 {
+			pipeline.Register(publisher)
+			continue
+		}
+		if publishers.OverflowPolicy(pool.Overflow) == publishers.OverflowSpill && cfg.Spool.Path == "" {
+			return nil, fmt.Errorf("%s publisher spills to the spool, but spool.path isn't set", publisherConfig.Type)
+		}
+		options := publishers.PoolOptions{Workers: pool.Workers, QueueSize: pool.QueueSize, Overflow: publishers.OverflowPolicy(pool.Overflow)}
+		if err := pipeline.RegisterPool(publisher, options); err != nil {
+			return nil, fmt.Errorf("%s publisher pool: %w", publisherConfig.Type, err)
+		}
+	}
+	return pipeline, nil
 }
 
-func (depper *Depper) registerIngestors() {
-//	depper.registerIngestor(ingestors.NewCocoaPods())
-	depper.registerIngestor(ingestors.NewRubyGems())
-	depper.registerIngestor(ingestors.NewElm())
-	depper.registerIngestor(ingestors.NewGo())
-	depper.registerIngestor(ingestors.NewMaven(ingestors.MavenCentral))
-	depper.registerIngestor(ingestors.NewMaven(ingestors.GoogleMaven))
-	depper.registerIngestor(ingestors.NewCargo())
-	depper.registerIngestor(ingestors.NewNuget())
-	depper.registerIngestor(ingestors.NewPackagist())
-	depper.registerIngestor(ingestors.NewCPAN())
-	depper.registerIngestor(ingestors.NewHex())
-	depper.registerIngestor(ingestors.NewHackage())
-	depper.registerIngestor(ingestors.NewPub())
-	// drupal is returning a 403 as of 2024-05-06. need to investigate but let's
-	// not block other ingestion as we do
-	//	depper.registerIngestor(ingestors.NewDrupal())
-	depper.registerIngestor(ingestors.NewPyPiRss())
-	depper.registerIngestor(ingestors.NewPyPiXmlRpc())
-	depper.registerIngestor(ingestors.NewConda(ingestors.CondaForge))
-	depper.registerIngestor(ingestors.NewConda(ingestors.CondaMain))
-	depper.registerIngestor(ingestors.NewNPM())
+func createPublisher(publisherConfig config.Publisher) (publishers.Publisher, error) {
+	switch publisherConfig.Type {
+	case "logging":
+		if !publisherConfig.Options.IsZero() {
+			return nil, fmt.Errorf("publisher type %q takes no options", publisherConfig.Type)
+		}
+		return &publishers.LoggingPublisher{}, nil
+	case "sidekiq":
+		var routing publishers.SidekiqRouting
+		if err := publisherConfig.DecodeOptions(&routing); err != nil {
+			return nil, fmt.Errorf("sidekiq publisher options: %w", err)
+		}
+		sidekiq, err := publishers.NewRoutedSidekiq(routing)
+		if err != nil {
+			return nil, fmt.Errorf("sidekiq publisher routing: %w", err)
+		}
+		return sidekiq, nil
+	case "webhook":
+		var options publishers.WebhookOptions
+		if err := publisherConfig.DecodeOptions(&options); err != nil {
+			return nil, fmt.Errorf("webhook publisher options: %w", err)
+		}
+		return publishers.NewWebhook(options)
+	case "redis_stream":
+		var options publishers.RedisStreamOptions
+		if err := publisherConfig.DecodeOptions(&options); err != nil {
+			return nil, fmt.Errorf("redis_stream publisher options: %w", err)
+		}
+		return publishers.NewRedisStream(options), nil
+	case "kafka":
+		var options publishers.KafkaOptions
+		if err := publisherConfig.DecodeOptions(&options); err != nil {
+			return nil, fmt.Errorf("kafka publisher options: %w", err)
+		}
+		return publishers.NewKafka(options)
+	default:
+		return nil, fmt.Errorf("unknown publisher type %q", publisherConfig.Type)
+	}
 }
 
-func (depper *Depper) registerIngestor(ingestor ingestors.PollingIngestor) {
-	c := cron.New()
-	ingestAndPublish := func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.WithFields(log.Fields{"ingestor": ingestor.Name(), "panic": r}).Error("ingestor panicked")
-			}
-		}()
-		span := tracer.StartSpan("ingest_and_publish")
-		span.SetTag("ingestor", ingestor.Name())
-		defer span.Finish()
+// Every ingestor Depper knows about, in the order they are registered. Use
+// the config file to disable any of them.
+func allIngestors() []ingestors.PollingIngestor {
+	return []ingestors.PollingIngestor{
+		ingestors.NewCocoaPods(),
+		ingestors.NewRubyGems(),
+		ingestors.NewElm(),
+		ingestors.NewGo(),
+		ingestors.NewMaven(ingestors.MavenCentral),
+		ingestors.NewMaven(ingestors.GoogleMaven),
+		ingestors.NewCargo(),
+		ingestors.NewNuget(),
+		ingestors.NewPackagist(),
+		ingestors.NewCPAN(),
+		ingestors.NewHex(),
+		ingestors.NewHackage(),
+		ingestors.NewPub(),
+		ingestors.NewDrupal(),
+		ingestors.NewPyPiRss(),
+		ingestors.NewPyPiXmlRpc(),
+		ingestors.NewConda(ingestors.CondaForge),
+		ingestors.NewConda(ingestors.CondaMain),
+		ingestors.NewNPM(),
+	}
+}
 
-		ttl := defaultTTL
+// Find an ingestor by its Name()
+func findIngestor(name string) (ingestors.PollingIngestor, error) {
+	for _, ingestor := range allIngestors() {
+		if ingestor.Name() == name {
+			return ingestor, nil
+		}
+	}
 
-		if ttler, ok := ingestor.(ingestors.TTLer); ok {
-			ttl = ttler.TTL()
+	return nil, fmt.Errorf("unknown ingestor %q", name)
+}
+
+// Check that every ingestor in the config exists.
+func validateIngestorConfigs(ingestorConfigs map[string]config.Ingestor) error {
+	for name, ingestorConfig := range ingestorConfigs {
+		if _, err := findIngestor(name); err != nil {
+			return fmt.Errorf("config for %w", err)
+		}
+		// Parsed as cron.New() does, so a typo fails startup rather than
+		// the ingestor's registration once Depper is up
+		if ingestorConfig.Schedule != "" {
+			if _, err := cron.ParseStandard(ingestorConfig.Schedule); err != nil {
+				return fmt.Errorf("config for %s ingestor: invalid schedule %q: %w", name, ingestorConfig.Schedule, err)
+			}
+		}
+		// Zero leaves the ingestor's own TTL
+		if ingestorConfig.TTL != 0 {
+			if err := publishers.ValidateTTL(ingestorConfig.TTL); err != nil {
+				return fmt.Errorf("config for %s ingestor: %w", name, err)
+			}
 		}
+	}
 
-		ingestSpan := tracer.StartSpan("ingest", tracer.ChildOf(span.Context()))
-		packageVersions := ingestor.Ingest()
-		ingestSpan.Finish()
+	return nil
+}
 
-		publishSpan := tracer.StartSpan("publish", tracer.ChildOf(span.Context()))
-		for _, packageVersion := range packageVersions {
-			depper.pipeline.Publish(ttl, packageVersion)
+func (depper *Depper) registerIngestors(ingestorConfigs map[string]config.Ingestor) {
+	for _, ingestor := range allIngestors() {
+		ingestorConfig := ingestorConfigs[ingestor.Name()]
+		if !ingestorConfig.IsEnabled() {
+			log.WithFields(log.Fields{"ingestor": ingestor.Name()}).Info("ingestor disabled by config")
+			continue
+		}
+		if !depper.registerIngestor(newScheduledIngestor(ingestor, ingestorConfig)) {
+			return
 		}
-		publishSpan.Finish()
 	}
+}
 
-	_, err := c.AddFunc(ingestor.Schedule(), ingestAndPublish)
+// Schedule the ingestor and run it once. Returns false if shutdown has
+// already started.
+func (depper *Depper) registerIngestor(scheduled *scheduledIngestor) bool {
+	c := cron.New()
+	ingestAndPublish := func() {
+		// Errors are already logged, and the next tick will try again.
+		_ = depper.ingestAndPublish(scheduled)
+	}
+
+	_, err := c.AddFunc(scheduled.schedule, ingestAndPublish)
 	if err != nil {
 		log.Fatal(err)
 	}
+	scheduled.cron = c
+
+	scheduled.loadBookmarkTime(depper.ctx)
 
+	depper.mu.Lock()
+	if depper.stopping {
+		depper.mu.Unlock()
+		return false
+	}
+	depper.scheduled = append(depper.scheduled, scheduled)
 	c.Start()
+	depper.mu.Unlock()
 
 	// For now we'll run once upon registration
 	ingestAndPublish()
+
+	return true
 }
 
 func setupLogger() {
@@ -166,3 +392,9 @@ func setupLogger() {
 		log.SetLevel(log.InfoLevel)
 	}
 }
+
+// Send every log level to stderr, keeping stdout free for command output.
+func logToStderr() {
+	log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	log.SetOutput(os.Stderr)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/config"
+)
+
+func TestCreatePipeline_Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr bool
+	}{
+		{
+			name: "options on a publisher that takes none",
+			yaml: `
+publishers:
+  - type: logging
+    options:
+      level: debug
+`,
+			wantErr: true,
+		},
+		{
+			name: "sidekiq routing",
+			yaml: `
+publishers:
+  - type: sidekiq
+    options:
+      queue: critical
+      rules:
+        - platforms: [npm]
+          events: [name_only]
+          queue: default
+          retry: 3
+`,
+		},
+		{
+			name: "misspelled sidekiq option",
+			yaml: `
+publishers:
+  - type: sidekiq
+    options:
+      rulez: []
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid sidekiq retry",
+			yaml: `
+publishers:
+  - type: sidekiq
+    options:
+      retry: sometimes
+`,
+			wantErr: true,
+		},
+		{
+			name: "logging with a pool of its own",
+			yaml: `
+publishers:
+  - type: logging
+    pool:
+      workers: 2
+      queue_size: 100
+      overflow: drop_oldest
+`,
+		},
+		{
+			name: "pool on the dedup publisher",
+			yaml: `
+publishers:
+  - type: sidekiq
+    pool:
+      workers: 2
+`,
+			wantErr: true,
+		},
+		{
+			name: "pool spilling without a spool",
+			yaml: `
+publishers:
+  - type: logging
+    pool:
+      workers: 1
+      overflow: spill
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.Parse(strings.NewReader(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := createPipeline(cfg); (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateIngestorConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs map[string]config.Ingestor
+		wantErr bool
+	}{
+		{name: "ttl", configs: map[string]config.Ingestor{"npm": {TTL: time.Hour}}},
+		{name: "default ttl", configs: map[string]config.Ingestor{"npm": {}}},
+		{name: "negative ttl", configs: map[string]config.Ingestor{"npm": {TTL: -time.Hour}}, wantErr: true},
+		{name: "schedule", configs: map[string]config.Ingestor{"npm": {Schedule: "*/5 * * * *"}}},
+		{name: "schedule descriptor", configs: map[string]config.Ingestor{"npm": {Schedule: "@every 1m"}}},
+		{name: "invalid schedule", configs: map[string]config.Ingestor{"npm": {Schedule: "*/5 * * *"}}, wantErr: true},
+		{name: "unknown ingestor", configs: map[string]config.Ingestor{"left-pad": {}}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateIngestorConfigs(test.configs); (err != nil) != test.wantErr {
+				t.Errorf("validateIngestorConfigs() = %v, want an error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
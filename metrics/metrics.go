@@ -0,0 +1,144 @@
+// Package metrics holds the Prometheus collectors exported on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/librariesio/depper/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ReleasesDiscovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_releases_discovered_total",
+		Help: "Releases returned by ingestors, before deduplication.",
+	}, []string{"platform"})
+
+	ReleasesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_releases_published_total",
+		Help: "Releases handed to publishers.",
+	}, []string{"platform"})
+
+	DedupHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_dedup_hits_total",
+		Help: "Releases skipped because they were already published within their TTL.",
+	}, []string{"platform"})
+
+	DedupCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_dedup_cache_lookups_total",
+		Help: "Dedup keys looked up in the in-process cache before Redis, by result: hit or miss.",
+	}, []string{"result"})
+
+	DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_dead_letters_total",
+		Help: "Releases a publisher gave up on after retrying.",
+	}, []string{"publisher"})
+
+	PoolOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_publisher_queue_overflows_total",
+		Help: "Releases that found a publisher's queue full, by the overflow policy applied.",
+	}, []string{"publisher", "policy"})
+
+	SpooledReleases = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "depper_spooled_releases_total",
+		Help: "Releases written to the on-disk spool because Redis couldn't take them.",
+	})
+
+	RegistryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "depper_registry_requests_total",
+		Help: "HTTP requests made to package registries, by host and status code.",
+	}, []string{"registry", "code"})
+
+	DiscoveryLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "depper_discovery_lag_seconds",
+		Help: "Time between a registry creating a release and depper discovering it.",
+		// 1s up to ~4.5 days
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"platform"})
+)
+
+// Count a batch of releases returned by an ingestor. Releases without a
+// CreatedAt, like npm's, have no lag to observe.
+func ObserveDiscovered(packageVersions []data.PackageVersion) {
+	for _, packageVersion := range packageVersions {
+		ReleasesDiscovered.WithLabelValues(packageVersion.Platform).Inc()
+		if !packageVersion.CreatedAt.IsZero() {
+			DiscoveryLag.WithLabelValues(packageVersion.Platform).Observe(packageVersion.DiscoveryLag.Seconds())
+		}
+	}
+}
+
+// Wrap an http.RoundTripper so every request is counted in RegistryRequests.
+// Requests that fail before getting a response are recorded with code "error".
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		response, err := next.RoundTrip(req)
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(response.StatusCode)
+		}
+		RegistryRequests.WithLabelValues(req.URL.Host, code).Inc()
+
+		return response, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Report the pipeline's queue depth, read from depth on every scrape.
+func RegisterQueueDepth(depth func() int) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "depper_pipeline_queue_depth",
+		Help: "Releases waiting in the publishing pipeline.",
+	}, func() float64 { return float64(depth()) }))
+}
+
+// Report the spool's size and the age of its oldest release, read from size
+// and age on every scrape.
+func RegisterSpool(size func() int64, age func() time.Duration) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "depper_spool_bytes",
+		Help: "Bytes of releases waiting in the on-disk spool for Redis to come back.",
+	}, func() float64 { return float64(size()) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "depper_spool_oldest_age_seconds",
+		Help: "Seconds the oldest release in the on-disk spool has been waiting.",
+	}, func() float64 { return age().Seconds() }))
+}
+
+// Report how long ago each ingestor's bookmark last advanced. updatedAt is
+// called on every scrape and returns, keyed by ingestor name, when this
+// process last committed the bookmark, or the time a time-based bookmark
+// holds until it has.
+func RegisterBookmarkAge(updatedAt func() map[string]time.Time) {
+	prometheus.MustRegister(&bookmarkAgeCollector{
+		desc: prometheus.NewDesc(
+			"depper_bookmark_age_seconds",
+			"Seconds since this process last committed the ingestor's bookmark or, until it has, since the time held by a time-based bookmark. Missing for other ingestors until their first commit.",
+			[]string{"ingestor"}, nil,
+		),
+		updatedAt: updatedAt,
+	})
+}
+
+type bookmarkAgeCollector struct {
+	desc      *prometheus.Desc
+	updatedAt func() map[string]time.Time
+}
+
+func (collector *bookmarkAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+func (collector *bookmarkAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	for ingestor, updatedAt := range collector.updatedAt() {
+		ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, time.Since(updatedAt).Seconds(), ingestor)
+	}
+}
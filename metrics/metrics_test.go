@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/librariesio/depper/data"
+)
+
+func TestInstrumentTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	client := &http.Client{Transport: InstrumentTransport(http.DefaultTransport)}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if got := testutil.ToFloat64(RegistryRequests.WithLabelValues(host, "429")); got != 1 {
+		t.Errorf("429 requests = %v, want 1", got)
+	}
+
+	unreachable := &url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+	if _, err := client.Get(unreachable.String()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := testutil.ToFloat64(RegistryRequests.WithLabelValues(unreachable.Host, "error")); got != 1 {
+		t.Errorf("failed requests = %v, want 1", got)
+	}
+}
+
+func TestObserveDiscovered_SkipsLagWithoutCreatedAt(t *testing.T) {
+	ObserveDiscovered([]data.PackageVersion{
+		{Platform: "dated", Name: "left-pad", CreatedAt: time.Now().Add(-time.Minute), DiscoveryLag: time.Minute},
+		{Platform: "undated", Name: "left-pad"},
+	})
+
+	if got := testutil.ToFloat64(ReleasesDiscovered.WithLabelValues("undated")); got != 1 {
+		t.Errorf("undated releases discovered = %v, want 1", got)
+	}
+	// Only the dated platform has a histogram, as nothing was observed for the other
+	if got := testutil.CollectAndCount(DiscoveryLag); got != 1 {
+		t.Errorf("lag histograms = %d, want 1", got)
+	}
+}
@@ -0,0 +1,226 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+func (pipeline *Pipeline) run() {
+	defer close(pipeline.done)
+
+	for {
+		batch, ok := pipeline.nextBatch(pipeline.queue)
+		if !ok {
+			return
+		}
+
+		select {
+		case <-pipeline.abort:
+			for _, publishing := range batch {
+				pipeline.drop(publishing)
+			}
+			continue
+		default:
+		}
+
+		// Forced and replayed releases skip the dedup check, so they go
+		// through one at a time.
+		regular := make([]publishing, 0, len(batch))
+		for _, publishing := range batch {
+			if publishing.force || publishing.only != nil {
+				publishing.finish(pipeline.process(publishing))
+			} else {
+				regular = append(regular, publishing)
+			}
+		}
+		if len(regular) > 0 {
+			pipeline.processBatch(regular)
+		}
+	}
+}
+
+// Wait for a release on queue, then take whatever else is already queued, up
+// to the batch size. Returns false once the queue is closed and empty.
+func (pipeline *Pipeline) nextBatch(queue <-chan publishing) ([]publishing, bool) {
+	first, ok := <-queue
+	if !ok {
+		return nil, false
+	}
+
+	batch := []publishing{first}
+	for len(batch) < pipeline.batchSize {
+		select {
+		case publishing, ok := <-queue:
+			if !ok {
+				return batch, true
+			}
+			batch = append(batch, publishing)
+		default:
+			return batch, true
+		}
+	}
+
+	return batch, true
+}
+
+// The outcome of publishing one release of a batch
+type batchResult struct {
+	// Some publisher failed
+	failed bool
+	errs   []error
+}
+
+func (result *batchResult) record(err error) {
+	if err != nil {
+		result.failed = true
+		if !isParked(err) {
+			result.errs = append(result.errs, err)
+		}
+	}
+}
+
+// Deduplicate a batch in one round trip and hand the new releases to each
+// publisher, as a slice to those that implement BatchPublisher. Anything that
+// fails as a batch is retried one release at a time, so retries and dead
+// letters work as they do for single releases.
+func (pipeline *Pipeline) processBatch(batch []publishing) {
+	batch = pipeline.skipCached(batch)
+	if len(batch) == 0 {
+		return
+	}
+
+	results := make([]batchResult, len(batch))
+	// Releases the dedup publisher failed on, which it left no key for
+	dedupFailed := make([]bool, len(batch))
+	fresh := make([]int, 0, len(batch))
+	publishers := pipeline.publishers
+
+	dedup, hasDedup := pipeline.dedupPublisher()
+	if hasDedup {
+		// The dedup publisher sets the dedup key if and only if it publishes
+		// the release, so a crash can't leave a key for a lost release.
+		releases := make([]DedupRelease, len(batch))
+		for i := range batch {
+			releases[i] = batch[i].dedupRelease()
+		}
+
+		published, errs := dedup.PublishOnce(context.Background(), releases)
+		if allFailed(errs) && pipeline.spoolIfRedisDown(batch) {
+			return
+		}
+		for i := range batch {
+			if errs[i] != nil {
+				log.WithFields(log.Fields{"publisher": dedup.Name(), "error": errs[i]}).Warn("batch publish failed, retrying on its own")
+				itemPublished, itemErr := pipeline.publishOnce(dedup, batch[i])
+				results[i].record(itemErr)
+				dedupFailed[i] = itemErr != nil
+				if itemErr == nil && !itemPublished {
+					continue
+				}
+			} else if !published[i] {
+				continue
+			}
+			fresh = append(fresh, i)
+		}
+		publishers = pipeline.publishersExcept(dedup)
+	} else {
+		shouldPublish, err := pipeline.shouldPublish(batch)
+		if err != nil && pipeline.spoolIfRedisDown(batch) {
+			return
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"publisher": "pipeline"}).Error(err)
+			for _, publishing := range batch {
+				publishing.finish(err)
+			}
+			return
+		}
+		for i := range batch {
+			if shouldPublish[i] {
+				fresh = append(fresh, i)
+			}
+		}
+	}
+
+	pipeline.recordDedup(batch, fresh)
+
+	var pools []*publisherPool
+	for _, publisher := range publishers {
+		if pool := pipeline.poolFor(publisher); pool != nil {
+			pools = append(pools, pool)
+			continue
+		}
+		pipeline.publishBatchTo(publisher, batch, fresh, results)
+	}
+
+	// Before the pools get the releases, since a pool that fails or drops
+	// one releases its key, which forgets it again
+	pipeline.rememberDedupKeys(batch, fresh, func(i int) bool {
+		return !dedupFailed[i] && (hasDedup || !results[i].failed)
+	})
+
+	for _, pool := range pools {
+		pool.submitBatch(batch, fresh, results)
+	}
+
+	for i, publishing := range batch {
+		// Let the release be published again if an ingestor finds it again.
+		// The dedup publisher only sets the key once it has enqueued the
+		// release, and a failure of its own leaves no key, so the key is
+		// only released when the pipeline set it.
+		if results[i].failed && !hasDedup {
+			pipeline.releaseDedupKey(publishing)
+		}
+		publishing.finish(errors.Join(results[i].errs...))
+	}
+}
+
+func (pipeline *Pipeline) publishBatchTo(publisher Publisher, batch []publishing, fresh []int, results []batchResult) {
+	if len(fresh) == 0 {
+		return
+	}
+
+	if batchPublisher, ok := publisher.(BatchPublisher); ok && len(fresh) > 1 {
+		packageVersions := make([]data.PackageVersion, len(fresh))
+		for j, i := range fresh {
+			packageVersions[j] = batch[i].PackageVersion
+		}
+		err := batchPublisher.PublishBatch(packageVersions)
+		if err == nil {
+			return
+		}
+		log.WithFields(log.Fields{"publisher": publisher.Name(), "error": err}).Warn("batch publish failed, retrying one at a time")
+	}
+
+	for _, i := range fresh {
+		results[i].record(pipeline.publishTo(publisher, batch[i]))
+	}
+}
+
+func (pipeline *Pipeline) recordDedup(batch []publishing, fresh []int) {
+	isFresh := make(map[int]bool, len(fresh))
+	for _, i := range fresh {
+		isFresh[i] = true
+	}
+	for i, publishing := range batch {
+		if isFresh[i] {
+			metrics.ReleasesPublished.WithLabelValues(publishing.Platform).Inc()
+		} else {
+			metrics.DedupHits.WithLabelValues(publishing.Platform).Inc()
+		}
+	}
+}
+
+func allFailed(errs []error) bool {
+	for _, err := range errs {
+		if err == nil {
+			return false
+		}
+	}
+
+	return len(errs) > 0
+}
@@ -0,0 +1,119 @@
+package publishers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// Records the size of every batch it's given.
+type batchRecordingPublisher struct {
+	recordingPublisher
+	batchMu sync.Mutex
+	batches []int
+}
+
+func (publisher *batchRecordingPublisher) PublishBatch(packageVersions []data.PackageVersion) error {
+	publisher.batchMu.Lock()
+	publisher.batches = append(publisher.batches, len(packageVersions))
+	publisher.batchMu.Unlock()
+
+	for _, packageVersion := range packageVersions {
+		if err := publisher.Publish(packageVersion); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Signals started when it's first called, then waits on release.
+type blockingPublisher struct {
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (publisher *blockingPublisher) Name() string {
+	return "blocking"
+}
+
+func (publisher *blockingPublisher) Publish(packageVersion data.PackageVersion) error {
+	publisher.once.Do(func() { close(publisher.started) })
+	<-publisher.release
+
+	return nil
+}
+
+func TestPipeline_PublishesInBatches(t *testing.T) {
+	server := setupTestRedis(t)
+	publisher := &batchRecordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+	pipeline.Register(NewSidekiq())
+	pipeline.SetBatchSize(10)
+
+	// Hold the pipeline up in a batch of its own until everything else is
+	// queued, so the following batches are full.
+	blocker := &blockingPublisher{started: make(chan struct{}), release: make(chan struct{})}
+	pipeline.publishers = append([]Publisher{blocker}, pipeline.publishers...)
+	if err := enqueue(pipeline, data.PackageVersion{Platform: "npm", Name: "blocker", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	<-blocker.started
+
+	packageVersions := testPackageVersions(25)
+	done := make(chan error)
+	go func() { done <- pipeline.PublishAll(context.Background(), time.Hour, packageVersions) }()
+	for pipeline.QueueDepth() < len(packageVersions) {
+		time.Sleep(time.Millisecond)
+	}
+	close(blocker.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(publisher.batches) != "[10 10 5]" {
+		t.Errorf("batches = %v, want [10 10 5]", publisher.batches)
+	}
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 26 {
+		t.Errorf("enqueued %d jobs, want 26", len(jobs))
+	}
+
+	// Everything is deduplicated the second time round.
+	if err := pipeline.PublishAll(context.Background(), time.Hour, packageVersions); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 26 {
+		t.Errorf("enqueued %d jobs after publishing again, want 26", len(jobs))
+	}
+}
+
+// Compares publishing one release at a time with batching, through the
+// Sidekiq publisher against an in-process Redis.
+func BenchmarkPipeline(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	for _, batchSize := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("batch_size=%d", batchSize), func(b *testing.B) {
+			setupTestRedis(b)
+			pipeline := NewPipeline()
+			pipeline.Register(NewSidekiq())
+			pipeline.SetBatchSize(batchSize)
+
+			packageVersions := make([]data.PackageVersion, b.N)
+			for i := range packageVersions {
+				packageVersions[i] = data.PackageVersion{Platform: "npm", Name: "pkg", Version: fmt.Sprint(i)}
+			}
+
+			b.ResetTimer()
+			if err := pipeline.PublishAll(context.Background(), time.Hour, packageVersions); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "releases/s")
+		})
+	}
+}
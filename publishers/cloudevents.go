@@ -0,0 +1,75 @@
+package publishers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/librariesio/depper/data"
+)
+
+const (
+	// The CloudEvents type of every release Depper publishes
+	ReleasePublishedType = "io.libraries.depper.release.published"
+	// Content type of a CloudEvent in structured mode
+	CloudEventContentType = "application/cloudevents+json"
+	// Source of releases that no ingestor found, e.g. `depper publish`
+	defaultEventSource = "depper"
+)
+
+// A release as a CloudEvents 1.0 event in its JSON format. See
+// schemas/release-published.json.
+type CloudEvent struct {
+	SpecVersion     string              `json:"specversion"`
+	ID              string              `json:"id"`
+	Source          string              `json:"source"`
+	Type            string              `json:"type"`
+	Subject         string              `json:"subject"`
+	Time            *time.Time          `json:"time,omitempty"`
+	DataContentType string              `json:"datacontenttype"`
+	Data            data.PackageVersion `json:"data"`
+}
+
+// Wrap packageVersion in a CloudEvent. The ID only depends on the platform,
+// name and version, and distinct actions like a yank, so consumers can use it
+// to drop releases they have seen.
+func NewReleaseEvent(packageVersion data.PackageVersion) CloudEvent {
+	event := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              releaseEventID(packageVersion),
+		Source:          packageVersion.Ingestor,
+		Type:            ReleasePublishedType,
+		Subject:         packageVersion.Platform + ":" + packageVersion.Name,
+		DataContentType: "application/json",
+		Data:            packageVersion,
+	}
+	if event.Source == "" {
+		event.Source = defaultEventSource
+	}
+	if !packageVersion.CreatedAt.IsZero() {
+		createdAt := packageVersion.CreatedAt.UTC()
+		event.Time = &createdAt
+	}
+
+	return event
+}
+
+func marshalReleaseEvent(packageVersion data.PackageVersion) ([]byte, error) {
+	return json.Marshal(NewReleaseEvent(packageVersion))
+}
+
+func releaseEventID(packageVersion data.PackageVersion) string {
+	hash := sha256.New()
+	// Separated by NUL so that e.g. "a/b" "c" and "a" "b/c" differ
+	parts := []string{packageVersion.Platform, packageVersion.Name, packageVersion.Version}
+	if packageVersion.Action.IsDistinct() {
+		parts = append(parts, string(packageVersion.Action))
+	}
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
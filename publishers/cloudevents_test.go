@@ -0,0 +1,126 @@
+package publishers
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/librariesio/depper/data"
+)
+
+func compileReleaseSchema(t *testing.T) *jsonschema.Schema {
+	file, err := os.Open("../schemas/release-published.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	doc, err := jsonschema.UnmarshalJSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	if err := compiler.AddResource("release-published.json", doc); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := compiler.Compile("release-published.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+func TestReleaseEvent_MatchesSchema(t *testing.T) {
+	schema := compileReleaseSchema(t)
+
+	tests := []struct {
+		name           string
+		packageVersion data.PackageVersion
+	}{
+		{
+			name: "full release",
+			packageVersion: data.PackageVersion{
+				Platform:     "npm",
+				Name:         "@babel/core",
+				Version:      "7.24.5",
+				CreatedAt:    time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("CEST", 2*60*60)),
+				DiscoveryLag: 1500 * time.Millisecond,
+				Sequence:     "42",
+				Delay:        time.Minute,
+				Ingestor:     "npm",
+				Action:       data.ActionNew,
+			},
+		},
+		{
+			name:           "name only, published by hand",
+			packageVersion: data.PackageVersion{Platform: "npm", Name: "left-pad"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := marshalReleaseEvent(test.packageVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := schema.Validate(instance); err != nil {
+				t.Errorf("%s doesn't match the schema: %v", encoded, err)
+			}
+
+			// And the schema does check them
+			instance.(map[string]any)["type"] = "io.libraries.depper.release.yanked"
+			if err := schema.Validate(instance); err == nil {
+				t.Error("expected an event of another type not to match")
+			}
+		})
+	}
+}
+
+func TestReleaseEvent_Fields(t *testing.T) {
+	packageVersion := data.PackageVersion{Platform: "pypi", Name: "requests", Version: "2.32.0", Ingestor: "pypiRss"}
+	event := NewReleaseEvent(packageVersion)
+
+	if event.Source != "pypiRss" || event.Type != ReleasePublishedType || event.Subject != "pypi:requests" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// Found again later, by another ingestor, the release keeps its id
+	packageVersion.Ingestor = "pypiXmlRpc"
+	packageVersion.Sequence = "99"
+	if again := NewReleaseEvent(packageVersion); again.ID != event.ID {
+		t.Errorf("id changed from %s to %s", event.ID, again.ID)
+	}
+	for _, other := range []data.PackageVersion{
+		{Platform: "pypi", Name: "requests", Version: "2.32.1"},
+		{Platform: "pypi", Name: "requests2", Version: ".32.0"},
+		{Platform: "npm", Name: "requests", Version: "2.32.0"},
+	} {
+		if NewReleaseEvent(other).ID == event.ID {
+			t.Errorf("%+v has the same id as %+v", other, packageVersion)
+		}
+	}
+
+	// New releases keep the id they had before releases had actions, and a
+	// yank gets one of its own
+	packageVersion.Action = data.ActionNew
+	if again := NewReleaseEvent(packageVersion); again.ID != event.ID {
+		t.Errorf("new release's id changed from %s to %s", event.ID, again.ID)
+	}
+	packageVersion.Action = data.ActionYank
+	if yank := NewReleaseEvent(packageVersion); yank.ID == event.ID {
+		t.Error("expected a yank to have an id of its own")
+	}
+
+	if event := NewReleaseEvent(data.PackageVersion{Platform: "npm", Name: "left-pad"}); event.Source != "depper" || event.Time != nil {
+		t.Errorf("unexpected event for a hand-published release %+v", event)
+	}
+}
@@ -0,0 +1,126 @@
+package publishers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/metrics"
+	"github.com/librariesio/depper/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// Redis list of releases a publisher gave up on, newest first
+const deadLettersKey = "depper:dead_letters"
+
+// How long a replayed release is deduplicated for if its dead letter
+// predates recording the TTL
+const defaultReplayTTL = 24 * time.Hour
+
+// A release that a publisher failed to publish even after retrying.
+type DeadLetter struct {
+	Publisher      string              `json:"publisher"`
+	PackageVersion data.PackageVersion `json:"package_version"`
+	Error          string              `json:"error"`
+	Attempts       int                 `json:"attempts"`
+	FailedAt       time.Time           `json:"failed_at"`
+	// How long the release was to be deduplicated for
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+
+	// The entry as stored, so it can be removed once replayed
+	raw string
+}
+
+func (pipeline *Pipeline) deadLetter(publisher Publisher, publishing publishing, attempts int, publishErr error) error {
+	encoded, err := json.Marshal(DeadLetter{
+		Publisher:      publisher.Name(),
+		PackageVersion: publishing.PackageVersion,
+		Error:          publishErr.Error(),
+		Attempts:       attempts,
+		FailedAt:       time.Now(),
+		TTLSeconds:     int64(publishing.ttl.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	if err := redis.Client.LPush(context.Background(), deadLettersKey, encoded).Err(); err != nil {
+		return err
+	}
+	metrics.DeadLetters.WithLabelValues(publisher.Name()).Inc()
+
+	return nil
+}
+
+// Every dead letter, oldest first.
+func (pipeline *Pipeline) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	entries, err := redis.Client.LRange(ctx, deadLettersKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		var deadLetter DeadLetter
+		if err := json.Unmarshal([]byte(entries[i]), &deadLetter); err != nil {
+			return nil, fmt.Errorf("invalid dead letter %q: %w", entries[i], err)
+		}
+		deadLetter.raw = entries[i]
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+// Send every dead letter back through the publisher that gave up on it,
+// oldest first, and return how many were published. Ones that fail again
+// are dead-lettered afresh. Ones whose publisher is no longer registered
+// are left where they are.
+func (pipeline *Pipeline) ReplayDeadLetters(ctx context.Context) (int, error) {
+	deadLetters, err := pipeline.DeadLetters(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	var errs []error
+	for _, deadLetter := range deadLetters {
+		publisher := pipeline.publisher(deadLetter.Publisher)
+		if publisher == nil {
+			errs = append(errs, fmt.Errorf("no %q publisher to replay %s/%s@%s through", deadLetter.Publisher, deadLetter.PackageVersion.Platform, deadLetter.PackageVersion.Name, deadLetter.PackageVersion.Version))
+			continue
+		}
+
+		result := make(chan error, 1)
+		ttl := time.Duration(deadLetter.TTLSeconds) * time.Second
+		if ttl <= 0 {
+			ttl = defaultReplayTTL
+		}
+		if err := pipeline.enqueue(ctx, publishing{PackageVersion: deadLetter.PackageVersion, ttl: ttl, only: publisher, result: result}); err != nil {
+			return replayed, err
+		}
+		select {
+		case err = <-result:
+		case <-ctx.Done():
+			return replayed, ctx.Err()
+		}
+
+		// On failure the pipeline has already parked a new entry, or has
+		// failed to and the old one stays.
+		if err == nil || errors.Is(err, errDeadLettered) {
+			if err := redis.Client.LRem(ctx, deadLettersKey, 1, deadLetter.raw).Err(); err != nil {
+				return replayed, err
+			}
+		}
+		if err == nil {
+			replayed++
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	log.WithFields(log.Fields{"publisher": "pipeline", "replayed": replayed, "failed": len(errs)}).Info("replayed dead letters")
+	return replayed, errors.Join(errs...)
+}
@@ -0,0 +1,232 @@
+package publishers
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librariesio/depper/metrics"
+	"github.com/librariesio/depper/redis"
+)
+
+// How many dedup keys the in-process cache holds, unless changed with
+// SetDedupCache
+const defaultDedupCacheSize = 100_000
+
+// How long the cache trusts a key, unless changed with SetDedupCache. Keys
+// can be deleted in Redis by other replicas or a forced publish, so no entry
+// outlives this however long its TTL.
+const defaultDedupCacheMaxTTL = time.Hour
+
+// An in-process LRU of dedup keys known to be set in Redis, in front of the
+// SETNX, so the releases ingestors keep finding again are skipped without a
+// round trip. Entries expire no later than their Redis key. Keys are kept as
+// 64-bit hashes to save memory.
+type dedupCache struct {
+	mu      sync.Mutex
+	size    int
+	maxTTL  time.Duration
+	seed    maphash.Seed
+	entries map[uint64]*list.Element
+	// Most recently used first
+	order *list.List
+
+	hits, misses uint64
+}
+
+type dedupCacheEntry struct {
+	hash      uint64
+	expiresAt time.Time
+}
+
+// How the cache is doing, for the status endpoint.
+type DedupCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func newDedupCache(size int, maxTTL time.Duration) *dedupCache {
+	return &dedupCache{
+		size:    size,
+		maxTTL:  maxTTL,
+		seed:    maphash.MakeSeed(),
+		entries: make(map[uint64]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Whether key is known to be set in Redis. A nil cache knows nothing.
+func (cache *dedupCache) seen(key string) bool {
+	if cache == nil {
+		return false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	hit := false
+	if element, ok := cache.entries[maphash.String(cache.seed, key)]; ok {
+		if time.Now().Before(element.Value.(*dedupCacheEntry).expiresAt) {
+			cache.order.MoveToFront(element)
+			hit = true
+		} else {
+			cache.removeElement(element)
+		}
+	}
+
+	if hit {
+		cache.hits++
+		metrics.DedupCacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		cache.misses++
+		metrics.DedupCacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	return hit
+}
+
+// Record that key is set in Redis for another ttl, evicting the least
+// recently used keys if the cache is full.
+func (cache *dedupCache) remember(key string, ttl time.Duration) {
+	if cache == nil {
+		return
+	}
+	ttl = min(ttl, cache.maxTTL)
+	if ttl <= 0 {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	hash := maphash.String(cache.seed, key)
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := cache.entries[hash]; ok {
+		element.Value.(*dedupCacheEntry).expiresAt = expiresAt
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[hash] = cache.order.PushFront(&dedupCacheEntry{hash: hash, expiresAt: expiresAt})
+	for cache.order.Len() > cache.size {
+		cache.removeElement(cache.order.Back())
+	}
+}
+
+func (cache *dedupCache) forget(key string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[maphash.String(cache.seed, key)]; ok {
+		cache.removeElement(element)
+	}
+}
+
+func (cache *dedupCache) removeElement(element *list.Element) {
+	cache.order.Remove(element)
+	delete(cache.entries, element.Value.(*dedupCacheEntry).hash)
+}
+
+func (cache *dedupCache) stats() DedupCacheStats {
+	if cache == nil {
+		return DedupCacheStats{}
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := DedupCacheStats{Entries: cache.order.Len(), Hits: cache.hits, Misses: cache.misses}
+	if lookups := cache.hits + cache.misses; lookups > 0 {
+		stats.HitRate = float64(cache.hits) / float64(lookups)
+	}
+
+	return stats
+}
+
+// Keep up to size dedup keys in memory, trusting each for at most maxTTL.
+// Zero values use the defaults of 100,000 keys and an hour, and a negative
+// size turns the cache off.
+func (pipeline *Pipeline) SetDedupCache(size int, maxTTL time.Duration) {
+	if size == 0 {
+		size = defaultDedupCacheSize
+	}
+	if maxTTL <= 0 {
+		maxTTL = defaultDedupCacheMaxTTL
+	}
+	if size < 0 {
+		pipeline.dedupCache = nil
+		return
+	}
+	pipeline.dedupCache = newDedupCache(size, maxTTL)
+}
+
+func (pipeline *Pipeline) DedupCacheStats() DedupCacheStats {
+	return pipeline.dedupCache.stats()
+}
+
+// Finish the releases the cache knows were already published within their
+// TTL, and return the rest to be checked in Redis.
+func (pipeline *Pipeline) skipCached(batch []publishing) []publishing {
+	unseen := batch[:0:0]
+	for _, publishing := range batch {
+		if pipeline.dedupCache.seen(publishing.Key()) {
+			metrics.DedupHits.WithLabelValues(publishing.Platform).Inc()
+			publishing.finish(nil)
+			continue
+		}
+		unseen = append(unseen, publishing)
+	}
+
+	return unseen
+}
+
+// Cache the dedup keys a processed batch left in Redis: the keys of fresh
+// releases that kept them, for their whole TTL, and the keys that were
+// already set, for whatever is left of theirs.
+func (pipeline *Pipeline) rememberDedupKeys(batch []publishing, fresh []int, keptKey func(i int) bool) {
+	if pipeline.dedupCache == nil {
+		return
+	}
+
+	isFresh := make(map[int]bool, len(fresh))
+	for _, i := range fresh {
+		isFresh[i] = true
+		if keptKey(i) {
+			pipeline.dedupCache.remember(batch[i].Key(), batch[i].ttl)
+		}
+	}
+
+	var existing []int
+	for i := range batch {
+		if !isFresh[i] && keptKey(i) {
+			existing = append(existing, i)
+		}
+	}
+	if len(existing) == 0 {
+		return
+	}
+
+	cmds := make([]*goredis.DurationCmd, len(existing))
+	_, err := redis.Dedup().Pipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		for j, i := range existing {
+			cmds[j] = pipe.PTTL(context.Background(), batch[i].Key())
+		}
+		return nil
+	})
+	if err != nil {
+		// Only costs the cache a few entries
+		log.WithFields(log.Fields{"publisher": "pipeline", "error": err}).Warn("couldn't read dedup key TTLs")
+		return
+	}
+	for j, i := range existing {
+		// Keys without an expiry, or gone already, report a negative TTL
+		pipeline.dedupCache.remember(batch[i].Key(), cmds[j].Val())
+	}
+}
@@ -0,0 +1,100 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDedupCache_EvictsLeastRecentlyUsedAndExpires(t *testing.T) {
+	cache := newDedupCache(2, time.Hour)
+
+	cache.remember("a", time.Hour)
+	cache.remember("b", time.Hour)
+	cache.seen("a")
+	cache.remember("c", time.Hour)
+	if !cache.seen("a") || cache.seen("b") || !cache.seen("c") {
+		t.Error("expected b, the least recently used key, to be evicted")
+	}
+
+	cache.remember("d", time.Millisecond)
+	// Never trusted for longer than the cache's max TTL, whatever the key's
+	cache.maxTTL = time.Millisecond
+	cache.remember("e", time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	if cache.seen("d") || cache.seen("e") {
+		t.Error("expected expired keys to be missed")
+	}
+
+	cache.remember("f", -1)
+	cache.forget("c")
+	if cache.seen("f") || cache.seen("c") {
+		t.Error("expected keys without a TTL, and forgotten keys, to be missed")
+	}
+
+	stats := cache.stats()
+	if stats.Hits != 3 || stats.Misses != 5 || stats.HitRate != 3.0/8 {
+		t.Errorf("stats = %+v, want 3 hits and 5 misses", stats)
+	}
+}
+
+func TestPipeline_SkipsRedisForCachedReleases(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	publisher := &recordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+	packageVersions := testPackageVersions(2)
+	key := (&publishing{PackageVersion: packageVersions[1]}).Key()
+
+	// Another replica published this one a while ago
+	server.Set(key, "1")
+	server.SetTTL(key, 10*time.Millisecond)
+
+	if err := pipeline.PublishAll(ctx, time.Hour, packageVersions); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 1 {
+		t.Fatalf("published %d releases, want the one that wasn't in Redis", len(publisher.published))
+	}
+
+	// With the keys gone from Redis, only the cache still knows about them
+	server.FlushAll()
+	if err := pipeline.PublishAll(ctx, time.Hour, packageVersions[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 1 || server.Exists((&publishing{PackageVersion: packageVersions[0]}).Key()) {
+		t.Error("expected a repeat to be skipped without going to Redis")
+	}
+
+	// The cache doesn't outlive the key it learned from Redis
+	time.Sleep(20 * time.Millisecond)
+	if err := pipeline.PublishAll(ctx, time.Hour, packageVersions[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 2 {
+		t.Errorf("published %d releases, want the release whose key expired published again", len(publisher.published))
+	}
+
+	if stats := pipeline.DedupCacheStats(); stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("stats = %+v, want 1 hit and 3 misses", stats)
+	}
+}
+
+func TestPipeline_DoesntCacheKeysReleasedAfterAFailure(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	publisher := &recordingPublisher{failures: 1, err: errors.New("timeout")}
+	pipeline := newTestPipeline(publisher)
+	pipeline.retry.maxAttempts = 1
+
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 1 {
+		t.Errorf("published %d releases, want the failed release published when it's found again", len(publisher.published))
+	}
+}
@@ -0,0 +1,30 @@
+package publishers
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/librariesio/depper/data"
+)
+
+// Writes each release as a line of JSON, e.g. to stdout for a dry run.
+type JSONLinesPublisher struct {
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+func NewJSONLinesPublisher(writer io.Writer) *JSONLinesPublisher {
+	return &JSONLinesPublisher{encoder: json.NewEncoder(writer)}
+}
+
+func (publisher *JSONLinesPublisher) Name() string {
+	return "json_lines"
+}
+
+func (publisher *JSONLinesPublisher) Publish(packageVersion data.PackageVersion) error {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	return publisher.encoder.Encode(packageVersion)
+}
@@ -0,0 +1,108 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/librariesio/depper/data"
+)
+
+type KafkaOptions struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	// How many replicas must have a release before it counts as published:
+	// all (default), one or none
+	Acks string `yaml:"acks"`
+}
+
+// The part of *kafka.Writer the publisher uses, so tests can stand in for a
+// broker.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+}
+
+// Writes each release to a Kafka topic as a CloudEvent, keyed by platform:name
+// so that every release of a package lands on the same partition, in order.
+type Kafka struct {
+	writer       kafkaWriter
+	writeTimeout time.Duration
+}
+
+func NewKafka(options KafkaOptions) (*Kafka, error) {
+	if len(options.Brokers) == 0 {
+		return nil, errors.New("kafka publisher needs at least one broker")
+	}
+	if options.Topic == "" {
+		return nil, errors.New("kafka publisher needs a topic")
+	}
+	acks := kafka.RequireAll
+	if options.Acks != "" {
+		if err := acks.UnmarshalText([]byte(options.Acks)); err != nil {
+			return nil, err
+		}
+	}
+
+	writer := &kafka.Writer{
+		Addr:  kafka.TCP(options.Brokers...),
+		Topic: options.Topic,
+		// Partition by key rather than round robin, for per-package ordering
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		// Writes are synchronous, so don't hold each one back waiting for
+		// more messages to batch it with
+		BatchTimeout: 10 * time.Millisecond,
+	}
+
+	return &Kafka{writer: writer, writeTimeout: 10 * time.Second}, nil
+}
+
+func (publisher *Kafka) Name() string {
+	return "kafka"
+}
+
+// Returns once the brokers have acknowledged the release, or with the error
+// that stopped them, so the pipeline retries or dead-letters it.
+func (publisher *Kafka) Publish(packageVersion data.PackageVersion) error {
+	return publisher.PublishBatch([]data.PackageVersion{packageVersion})
+}
+
+// Write every release in one request. If any of them isn't acknowledged the
+// pipeline retries them one at a time, so consumers may see duplicates.
+func (publisher *Kafka) PublishBatch(packageVersions []data.PackageVersion) error {
+	messages := make([]kafka.Message, len(packageVersions))
+	for i, packageVersion := range packageVersions {
+		message, err := kafkaMessage(packageVersion)
+		if err != nil {
+			return Permanent(err)
+		}
+		messages[i] = message
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.writeTimeout)
+	defer cancel()
+
+	err := publisher.writer.WriteMessages(ctx, messages...)
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		return fmt.Errorf("%d of %d releases weren't acknowledged: %w", writeErrors.Count(), len(messages), errors.Join(writeErrors...))
+	}
+
+	return err
+}
+
+func kafkaMessage(packageVersion data.PackageVersion) (kafka.Message, error) {
+	value, err := marshalReleaseEvent(packageVersion)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	return kafka.Message{
+		Key:     []byte(packageVersion.Platform + ":" + packageVersion.Name),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte(CloudEventContentType)}},
+	}, nil
+}
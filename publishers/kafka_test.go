@@ -0,0 +1,154 @@
+package publishers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/librariesio/depper/data"
+)
+
+// Stands in for a Kafka cluster: partitions messages by key like the real
+// writer's balancer, and doesn't acknowledge the first failures writes.
+type fakeKafkaBroker struct {
+	mu         sync.Mutex
+	partitions [][]kafka.Message
+	failures   int
+}
+
+func newFakeKafkaBroker(partitions int) *fakeKafkaBroker {
+	return &fakeKafkaBroker{partitions: make([][]kafka.Message, partitions)}
+}
+
+func (broker *fakeKafkaBroker) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.failures > 0 {
+		broker.failures--
+		writeErrors := make(kafka.WriteErrors, len(messages))
+		for i := range writeErrors {
+			writeErrors[i] = kafka.NotEnoughReplicas
+		}
+		return writeErrors
+	}
+
+	available := make([]int, len(broker.partitions))
+	for i := range available {
+		available[i] = i
+	}
+	balancer := &kafka.Hash{}
+	for _, message := range messages {
+		partition := balancer.Balance(message, available...)
+		broker.partitions[partition] = append(broker.partitions[partition], message)
+	}
+
+	return nil
+}
+
+func (broker *fakeKafkaBroker) messages() []kafka.Message {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	var messages []kafka.Message
+	for _, partition := range broker.partitions {
+		messages = append(messages, partition...)
+	}
+
+	return messages
+}
+
+func newTestKafka(broker *fakeKafkaBroker) *Kafka {
+	return &Kafka{writer: broker, writeTimeout: time.Second}
+}
+
+func TestKafka_KeepsEachPackageOnOnePartitionInOrder(t *testing.T) {
+	broker := newFakeKafkaBroker(8)
+	publisher := newTestKafka(broker)
+
+	var packageVersions []data.PackageVersion
+	for _, version := range []string{"1.0.0", "1.0.1", "1.1.0"} {
+		packageVersions = append(packageVersions,
+			data.PackageVersion{Platform: "npm", Name: "left-pad", Version: version},
+			data.PackageVersion{Platform: "pypi", Name: "requests", Version: version},
+		)
+	}
+	if err := publisher.PublishBatch(packageVersions[:4]); err != nil {
+		t.Fatal(err)
+	}
+	for _, packageVersion := range packageVersions[4:] {
+		if err := publisher.Publish(packageVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"npm:left-pad", "pypi:requests"} {
+		var found int
+		for _, partition := range broker.partitions {
+			var versions []string
+			for _, message := range partition {
+				if string(message.Key) != key {
+					continue
+				}
+				var event CloudEvent
+				if err := json.Unmarshal(message.Value, &event); err != nil {
+					t.Fatal(err)
+				}
+				versions = append(versions, event.Data.Version)
+			}
+			if len(versions) == 0 {
+				continue
+			}
+			found++
+			if len(versions) != 3 || versions[0] != "1.0.0" || versions[2] != "1.1.0" {
+				t.Errorf("%s has versions %v, want all three in order", key, versions)
+			}
+		}
+		if found != 1 {
+			t.Errorf("%s is on %d partitions, want 1", key, found)
+		}
+	}
+}
+
+func TestKafka_ReportsUnacknowledgedWrites(t *testing.T) {
+	broker := newFakeKafkaBroker(1)
+	broker.failures = 1
+	publisher := newTestKafka(broker)
+
+	err := publisher.PublishBatch(testPackageVersions(2))
+	if !errors.Is(err, kafka.NotEnoughReplicas) {
+		t.Errorf("err = %v, want the brokers' error", err)
+	}
+	if len(broker.messages()) != 0 {
+		t.Errorf("broker has %d messages, want none", len(broker.messages()))
+	}
+}
+
+func TestPipeline_RetriesAndDeadLettersUnacknowledgedKafkaWrites(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	broker := newFakeKafkaBroker(1)
+	broker.failures = 1
+	pipeline := newTestPipeline(newTestKafka(broker))
+
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.messages()) != 1 {
+		t.Errorf("broker has %d messages after a retry, want 1", len(broker.messages()))
+	}
+
+	broker.failures = 3
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(2)[1:]); err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := pipeline.DeadLetters(ctx)
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].Publisher != "kafka" {
+		t.Errorf("dead letters = %+v, %v, want the unacknowledged release", deadLetters, err)
+	}
+}
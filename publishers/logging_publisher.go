@@ -8,7 +8,11 @@ import (
 
 type LoggingPublisher struct{}
 
-func (publisher *LoggingPublisher) Publish(packageVersion data.PackageVersion) {
+func (publisher *LoggingPublisher) Name() string {
+	return "logging"
+}
+
+func (publisher *LoggingPublisher) Publish(packageVersion data.PackageVersion) error {
 	field := log.Fields{
 		"platform":     packageVersion.Platform,
 		"name":         packageVersion.Name,
@@ -20,8 +24,13 @@ func (publisher *LoggingPublisher) Publish(packageVersion data.PackageVersion) {
 	if packageVersion.Sequence != "" {
 		field["sequence"] = packageVersion.Sequence
 	}
+	if packageVersion.Action != "" {
+		field["action"] = packageVersion.Action
+	}
 
 	log.
 		WithFields(field).
 		Info("Depper publish")
+
+	return nil
 }
@@ -2,15 +2,31 @@ package publishers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/metrics"
 	"github.com/librariesio/depper/redis"
+
+	goredis "github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 )
 
 const maxQueueSize = 1000
 
+// How many queued releases are deduplicated and published together, unless
+// changed with SetBatchSize
+const defaultBatchSize = 100
+
+var ErrPipelineClosed = errors.New("pipeline is closed")
+
+// Returned when a publisher gave up on a release and it was parked in the
+// dead letter list
+var errDeadLettered = errors.New("dead-lettered")
+
 // Pipelines provide an interface for ingestors to place requests for
 // Libraries.io to retrieve more information about a release.
 // Typically, this is done via some sort of job queue like Sidekiq.
@@ -20,48 +36,366 @@ type Pipeline struct {
 	publishers      []Publisher
 	LastPublishedAt time.Time
 	queue           chan publishing
+	retry           retryPolicy
+	batchSize       int
+	// Where releases go when Redis can't take them, if set
+	spool *Spool
+	// Dedup keys known to be set in Redis, checked before it. Nil when off
+	dedupCache *dedupCache
+	// Publishers with workers of their own, registered with RegisterPool
+	pools []*publisherPool
+
+	// Guards closing the queue against concurrent sends
+	mu     sync.RWMutex
+	closed bool
+	// Closed by Close to drop whatever is left instead of publishing it
+	abort     chan struct{}
+	abortOnce sync.Once
+	done      chan struct{}
+	droppedMu sync.Mutex
+	dropped   []data.PackageVersion
 }
 
 func NewPipeline() *Pipeline {
 	pipeline := &Pipeline{
-		queue: make(chan publishing, maxQueueSize),
+		queue:      make(chan publishing, maxQueueSize),
+		retry:      defaultRetryPolicy,
+		batchSize:  defaultBatchSize,
+		dedupCache: newDedupCache(defaultDedupCacheSize, defaultDedupCacheMaxTTL),
+		abort:      make(chan struct{}),
+		done:       make(chan struct{}),
 	}
 	go pipeline.run()
 
 	return pipeline
 }
 
-// Add a job to the Libraries.io package processing queue
-func (pipeline *Pipeline) Publish(ttl time.Duration, packageVersion data.PackageVersion) {
-	pipeline.queue <- publishing{PackageVersion: packageVersion, ttl: ttl}
+func (pipeline *Pipeline) enqueue(ctx context.Context, publishing publishing) error {
+	pipeline.mu.RLock()
+	defer pipeline.mu.RUnlock()
+
+	if pipeline.closed {
+		return ErrPipelineClosed
+	}
+
+	select {
+	case pipeline.queue <- publishing:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Add jobs for every package version and wait until the pipeline has accepted
+// all of them. Returns the first error hit while processing them, or ctx's
+// error if it is done before everything was accepted.
+func (pipeline *Pipeline) PublishAll(ctx context.Context, ttl time.Duration, packageVersions []data.PackageVersion) error {
+	if err := ValidateTTL(ttl); err != nil {
+		return err
+	}
+	results := make(chan error, len(packageVersions))
+
+	for _, packageVersion := range packageVersions {
+		if err := pipeline.enqueue(ctx, publishing{PackageVersion: packageVersion, ttl: ttl, result: results}); err != nil {
+			return err
+		}
+	}
+
+	var firstErr error
+	for range packageVersions {
+		select {
+		case err := <-results:
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return firstErr
+}
+
+// Publish a release even if it was already published within ttl, and wait
+// until the pipeline has processed it. The dedup key is refreshed so
+// ingestors that find it again within ttl still skip it.
+func (pipeline *Pipeline) ForcePublish(ctx context.Context, ttl time.Duration, packageVersion data.PackageVersion) error {
+	if err := ValidateTTL(ttl); err != nil {
+		return err
+	}
+	result := make(chan error, 1)
+
+	if err := pipeline.enqueue(ctx, publishing{PackageVersion: packageVersion, ttl: ttl, force: true, result: result}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// How many publishings are waiting to be processed
+func (pipeline *Pipeline) QueueDepth() int {
+	return len(pipeline.queue)
+}
+
+// How many publishings can wait before PublishAll and ForcePublish block
+func (pipeline *Pipeline) QueueCapacity() int {
+	return cap(pipeline.queue)
+}
+
+// Stop accepting releases and wait until everything already queued has been
+// through every publisher, including those with pools. If ctx is done first,
+// whatever is still queued is dropped instead, and returned.
+func (pipeline *Pipeline) Close(ctx context.Context) []data.PackageVersion {
+	pipeline.mu.Lock()
+	if !pipeline.closed {
+		pipeline.closed = true
+		close(pipeline.queue)
+	}
+	pipeline.mu.Unlock()
+
+	pipeline.waitOrAbort(ctx, pipeline.done)
+
+	// Nothing is handed to the pools once the pipeline is done
+	pools := make(chan struct{})
+	go func() {
+		for _, pool := range pipeline.pools {
+			pool.close()
+		}
+		for _, pool := range pipeline.pools {
+			pool.running.Wait()
+		}
+		close(pools)
+	}()
+	pipeline.waitOrAbort(ctx, pools)
+
+	pipeline.droppedMu.Lock()
+	defer pipeline.droppedMu.Unlock()
+
+	return pipeline.dropped
+}
+
+// Wait for done, or if ctx is done first, abort and then wait for it.
+func (pipeline *Pipeline) waitOrAbort(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		pipeline.abortOnce.Do(func() { close(pipeline.abort) })
+		<-done
+	}
+}
+
+// Give up on a release because the pipeline was closed, keeping it for
+// Close to return.
+func (pipeline *Pipeline) drop(publishing publishing) {
+	pipeline.droppedMu.Lock()
+	pipeline.dropped = append(pipeline.dropped, publishing.PackageVersion)
+	pipeline.droppedMu.Unlock()
+
+	publishing.finish(ErrPipelineClosed)
+}
+
+// Publish a release that skips batching: either forced through every
+// publisher regardless of its dedup key, or sent to a single publisher
+// (only), e.g. to replay a dead letter. Everything else goes through
+// processBatch.
+func (pipeline *Pipeline) process(publishing publishing) error {
+	if publishing.only != nil {
+		// A dedup publisher must set the key along with publishing, and skips
+		// releases that have been published again since they failed.
+		if dedup, ok := publishing.only.(DedupPublisher); ok {
+			_, err := pipeline.publishOnce(dedup, publishing)
+			return err
+		}
+		return pipeline.publishTo(publishing.only, publishing)
+	}
+	// The key is set again below, for a TTL the cache no longer knows
+	pipeline.dedupCache.forget(publishing.Key())
+
+	failed := false
+	var errs []error
+	record := func(err error) {
+		if err != nil {
+			failed = true
+			if !isParked(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	publishers := pipeline.publishers
+	dedup, hasDedup := pipeline.dedupPublisher()
+	if hasDedup {
+		// Clear the key so the dedup publisher publishes the release and sets
+		// it again, keeping the key only for releases it has enqueued.
+		if err := redis.Dedup().Del(context.Background(), publishing.Key()).Err(); err != nil {
+			log.WithFields(log.Fields{"publisher": "pipeline"}).Error(err)
+			return err
+		}
+		_, err := pipeline.publishOnce(dedup, publishing)
+		record(err)
+		publishers = pipeline.publishersExcept(dedup)
+	} else if err := redis.Dedup().Set(context.Background(), publishing.Key(), true, publishing.ttl).Err(); err != nil {
+		log.WithFields(log.Fields{"publisher": "pipeline"}).Error(err)
+		return err
+	}
+	metrics.ReleasesPublished.WithLabelValues(publishing.Platform).Inc()
+
+	for _, publisher := range publishers {
+		record(pipeline.publishTo(publisher, publishing))
+	}
+
+	// Let the release be published again if an ingestor finds it again. The
+	// dedup publisher's key marks what it enqueued, so it's left alone.
+	if failed && !hasDedup {
+		pipeline.releaseDedupKey(publishing)
+	}
+
+	return errors.Join(errs...)
 }
 
-func (pipeline *Pipeline) run() {
-	for publishing := range pipeline.queue {
-		pipeline.process(publishing)
+func (pipeline *Pipeline) releaseDedupKey(publishing publishing) {
+	pipeline.dedupCache.forget(publishing.Key())
+	if err := redis.Dedup().Del(context.Background(), publishing.Key()).Err(); err != nil {
+		log.WithFields(log.Fields{"publisher": "pipeline", "key": publishing.Key(), "error": err}).Error("couldn't release dedup key")
 	}
 }
 
-func (pipeline *Pipeline) process(publishing publishing) {
-	if !pipeline.shouldPublish(publishing) {
-		return
+// Publish to a single publisher, retrying with exponential backoff. If it
+// still fails, the release is parked in the dead letter list.
+func (pipeline *Pipeline) publishTo(publisher Publisher, publishing publishing) error {
+	return pipeline.withRetries(publisher, publishing, func() error {
+		return publisher.Publish(publishing.PackageVersion)
+	})
+}
+
+// Let the dedup publisher publish a single release if it's new, retrying
+// with exponential backoff.
+func (pipeline *Pipeline) publishOnce(dedup DedupPublisher, publishing publishing) (bool, error) {
+	published := false
+	err := pipeline.withRetries(dedup, publishing, func() error {
+		results, errs := dedup.PublishOnce(context.Background(), []DedupRelease{publishing.dedupRelease()})
+		published = results[0]
+		return errs[0]
+	})
+
+	return published, err
+}
+
+// Call publish until it succeeds, backing off between attempts, and
+// dead-letter the release for publisher if it never does.
+func (pipeline *Pipeline) withRetries(publisher Publisher, publishing publishing, publish func() error) error {
+	fields := log.Fields{
+		"publisher": publisher.Name(),
+		"platform":  publishing.Platform,
+		"name":      publishing.Name,
+		"version":   publishing.Version,
+	}
+
+	var err error
+	attempts := 0
+retries:
+	for attempts < pipeline.retry.maxAttempts {
+		attempts++
+		if err = publish(); err == nil {
+			return nil
+		}
+		if isPermanent(err) || attempts == pipeline.retry.maxAttempts {
+			break
+		}
+
+		backoff := pipeline.retry.backoff(attempts)
+		log.WithFields(fields).WithFields(log.Fields{"error": err, "attempt": attempts, "backoff": backoff}).Warn("publish failed, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-pipeline.abort:
+			break retries
+		}
 	}
 
-	// Publish each packageversion to all publishers
+	log.WithFields(fields).WithFields(log.Fields{"error": err, "attempts": attempts}).Error("publish failed, dead-lettering")
+	if deadLetterErr := pipeline.deadLetter(publisher, publishing, attempts, err); deadLetterErr != nil {
+		// The dead letter list is in Redis too, so it's likely down
+		if pipeline.spool != nil {
+			spoolErr := pipeline.spoolRelease(publisher, publishing)
+			if spoolErr == nil {
+				log.WithFields(fields).WithFields(log.Fields{"error": deadLetterErr}).Warn("couldn't dead-letter release, spooled it")
+				return fmt.Errorf("%w: %w", errSpooled, err)
+			}
+			deadLetterErr = errors.Join(deadLetterErr, spoolErr)
+		}
+		log.WithFields(fields).WithFields(log.Fields{"error": deadLetterErr}).Error("couldn't dead-letter release, it is lost")
+		return errors.Join(err, deadLetterErr)
+	}
+
+	return fmt.Errorf("%w: %w", errDeadLettered, err)
+}
+
+// The first registered DedupPublisher, if any.
+func (pipeline *Pipeline) dedupPublisher() (DedupPublisher, bool) {
+	for _, publisher := range pipeline.publishers {
+		if dedup, ok := publisher.(DedupPublisher); ok {
+			return dedup, true
+		}
+	}
+
+	return nil, false
+}
+
+func (pipeline *Pipeline) publishersExcept(except Publisher) []Publisher {
+	publishers := make([]Publisher, 0, len(pipeline.publishers))
 	for _, publisher := range pipeline.publishers {
-		publisher.Publish(publishing.PackageVersion)
+		if publisher != except {
+			publishers = append(publishers, publisher)
+		}
 	}
+
+	return publishers
 }
 
-func (pipeline *Pipeline) shouldPublish(publishing publishing) bool {
-	wasSet, err := redis.Client.SetNX(context.Background(), publishing.Key(), true, publishing.ttl).Result()
+// The registered publisher with the given name, or nil.
+func (pipeline *Pipeline) publisher(name string) Publisher {
+	for _, publisher := range pipeline.publishers {
+		if publisher.Name() == name {
+			return publisher
+		}
+	}
 
+	return nil
+}
+
+// SETNX every publishing's dedup key in one round trip, returning which ones
+// are new.
+func (pipeline *Pipeline) shouldPublish(batch []publishing) ([]bool, error) {
+	cmds := make([]*goredis.BoolCmd, len(batch))
+	_, err := redis.Dedup().Pipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		for i, publishing := range batch {
+			cmds[i] = pipe.SetNX(context.Background(), publishing.Key(), true, publishing.ttl)
+		}
+		return nil
+	})
 	if err != nil {
-		log.WithFields(log.Fields{"publisher": "pipeline"}).Error(err)
-		return false
+		return nil, err
 	}
 
-	return wasSet
+	results := make([]bool, len(batch))
+	for i, cmd := range cmds {
+		results[i] = cmd.Val()
+	}
+
+	return results, nil
+}
+
+// Set how many queued releases are deduplicated and published together. 1
+// publishes them one at a time.
+func (pipeline *Pipeline) SetBatchSize(size int) {
+	if size > 0 {
+		pipeline.batchSize = size
+	}
 }
 
 func (pipeline *Pipeline) Register(publisher Publisher) {
@@ -0,0 +1,224 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/redis"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+func setupTestRedis(t testing.TB) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	redis.Client = goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redis.Client.Close() })
+
+	return server
+}
+
+// Records what it was given, optionally waiting on release before each one
+// and failing with err the first failures times.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published []data.PackageVersion
+	release   chan struct{}
+	failures  int
+	err       error
+}
+
+func (publisher *recordingPublisher) Name() string {
+	return "recording"
+}
+
+func (publisher *recordingPublisher) Publish(packageVersion data.PackageVersion) error {
+	if publisher.release != nil {
+		<-publisher.release
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	if publisher.failures > 0 {
+		publisher.failures--
+		return publisher.err
+	}
+	publisher.published = append(publisher.published, packageVersion)
+
+	return nil
+}
+
+func newTestPipeline(publisher Publisher) *Pipeline {
+	pipeline := NewPipeline()
+	pipeline.retry = retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	pipeline.Register(publisher)
+
+	return pipeline
+}
+
+func testPackageVersions(n int) []data.PackageVersion {
+	packageVersions := make([]data.PackageVersion, n)
+	for i := range packageVersions {
+		packageVersions[i] = data.PackageVersion{Platform: "npm", Name: "pkg", Version: string(rune('a' + i))}
+	}
+
+	return packageVersions
+}
+
+// Queue a release without waiting for it to be published
+func enqueue(pipeline *Pipeline, packageVersion data.PackageVersion) error {
+	return pipeline.enqueue(context.Background(), publishing{PackageVersion: packageVersion, ttl: time.Hour})
+}
+
+func TestPipeline_CloseDrainsQueue(t *testing.T) {
+	setupTestRedis(t)
+	publisher := &recordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+
+	for _, packageVersion := range testPackageVersions(5) {
+		if err := enqueue(pipeline, packageVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dropped := pipeline.Close(context.Background()); len(dropped) != 0 {
+		t.Errorf("dropped %d releases", len(dropped))
+	}
+	if len(publisher.published) != 5 {
+		t.Errorf("published %d releases, want 5", len(publisher.published))
+	}
+	if err := enqueue(pipeline, testPackageVersions(1)[0]); !errors.Is(err, ErrPipelineClosed) {
+		t.Errorf("publishing after close = %v, want ErrPipelineClosed", err)
+	}
+}
+
+func TestPipeline_CloseDropsAfterDeadline(t *testing.T) {
+	setupTestRedis(t)
+	publisher := &recordingPublisher{release: make(chan struct{})}
+	pipeline := newTestPipeline(publisher)
+	// A batch is published in full once it's started
+	pipeline.SetBatchSize(1)
+
+	for _, packageVersion := range testPackageVersions(3) {
+		if err := enqueue(pipeline, packageVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-pipeline.abort
+		// Let the release that was being published when the deadline hit finish
+		close(publisher.release)
+	}()
+
+	dropped := pipeline.Close(ctx)
+	if len(publisher.published) != 1 || len(dropped) != 2 {
+		t.Errorf("published %d and dropped %d, want 1 and 2", len(publisher.published), len(dropped))
+	}
+}
+
+func TestPipeline_RetriesTransientErrors(t *testing.T) {
+	setupTestRedis(t)
+	publisher := &recordingPublisher{failures: 2, err: errors.New("connection refused")}
+	pipeline := newTestPipeline(publisher)
+
+	if err := pipeline.PublishAll(context.Background(), time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 1 {
+		t.Errorf("published %d releases, want 1", len(publisher.published))
+	}
+}
+
+func TestPipeline_DeadLettersAndReplays(t *testing.T) {
+	server := setupTestRedis(t)
+	publisher := &recordingPublisher{failures: 3, err: errors.New("connection refused")}
+	pipeline := newTestPipeline(publisher)
+	packageVersion := testPackageVersions(1)[0]
+
+	// Dead-lettered releases don't fail the batch, so bookmarks can move on.
+	if err := pipeline.PublishAll(context.Background(), time.Hour, []data.PackageVersion{packageVersion}); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists((&publishing{PackageVersion: packageVersion}).Key()) {
+		t.Error("expected the dedup key to be released")
+	}
+
+	deadLetters, err := pipeline.DeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Publisher != "recording" || deadLetters[0].Attempts != 3 || deadLetters[0].PackageVersion.Version != packageVersion.Version {
+		t.Fatalf("unexpected dead letters %+v", deadLetters)
+	}
+
+	replayed, err := pipeline.ReplayDeadLetters(context.Background())
+	if err != nil || replayed != 1 {
+		t.Fatalf("replay = %d, %v", replayed, err)
+	}
+	if len(publisher.published) != 1 {
+		t.Errorf("published %d releases after replay, want 1", len(publisher.published))
+	}
+	if deadLetters, _ := pipeline.DeadLetters(context.Background()); len(deadLetters) != 0 {
+		t.Errorf("%d dead letters left after replay", len(deadLetters))
+	}
+}
+
+func TestPipeline_PermanentErrorsAreNotRetried(t *testing.T) {
+	setupTestRedis(t)
+	publisher := &recordingPublisher{failures: 1, err: Permanent(errors.New("bad release"))}
+	pipeline := newTestPipeline(publisher)
+
+	if err := pipeline.PublishAll(context.Background(), time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := pipeline.DeadLetters(context.Background())
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].Attempts != 1 {
+		t.Errorf("dead letters = %+v, %v", deadLetters, err)
+	}
+}
+
+func TestPipeline_DedupsDistinctActionsApart(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	publisher := &recordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+	release := data.PackageVersion{Platform: "pypi", Name: "requests", Version: "2.32.0"}
+	update := release
+	update.Action = data.ActionUpdate
+	yank := release
+	yank.Action = data.ActionYank
+
+	for _, packageVersions := range [][]data.PackageVersion{{release}, {update}, {yank}, {yank}} {
+		if err := pipeline.PublishAll(ctx, time.Hour, packageVersions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(publisher.published) != 2 || publisher.published[1].Action != data.ActionYank {
+		t.Errorf("published %+v, want the release and its yank once each", publisher.published)
+	}
+}
+
+func TestPipeline_RejectsTTLsWithoutExpiry(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	publisher := &recordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+
+	if err := pipeline.PublishAll(ctx, 0, testPackageVersions(1)); err == nil {
+		t.Error("expected PublishAll to reject a ttl of 0")
+	}
+	if err := pipeline.ForcePublish(ctx, -time.Hour, testPackageVersions(1)[0]); err == nil {
+		t.Error("expected ForcePublish to reject a negative ttl")
+	}
+	if len(publisher.published) != 0 || len(server.Keys()) != 0 {
+		t.Errorf("published %v and set %v, want nothing", publisher.published, server.Keys())
+	}
+}
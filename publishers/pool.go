@@ -0,0 +1,249 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librariesio/depper/metrics"
+)
+
+// What a publisher's pool does with a release when its queue is full.
+type OverflowPolicy string
+
+const (
+	// Wait for room, holding up the pipeline and every other publisher
+	OverflowBlock OverflowPolicy = "block"
+	// Drop the oldest queued release to make room
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// Write the release to the spool, which replays it into the queue later
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// Finishes the releases a drop_oldest pool drops to make room
+var ErrPoolQueueFull = errors.New("publisher queue is full")
+
+// How many releases a pool's queue holds, unless its options say otherwise
+const defaultPoolQueueSize = 1000
+
+type PoolOptions struct {
+	// How many releases are published at once. With more than one,
+	// releases can be published out of order
+	Workers int
+	// How many releases can wait. Zero uses the default of 1000
+	QueueSize int
+	// Block, the default, drop_oldest or spill
+	Overflow OverflowPolicy
+}
+
+// Workers publishing to one publisher from a queue of its own, so a slow
+// publisher holds up neither the pipeline nor the others.
+type publisherPool struct {
+	pipeline  *Pipeline
+	publisher Publisher
+	workers   int
+	overflow  OverflowPolicy
+	queue     chan publishing
+
+	// Guards closing the queue against concurrent sends
+	mu      sync.RWMutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+// Register publisher with a pool of workers of its own. The pipeline hands
+// it releases once they have been deduplicated, without waiting for them to
+// be published, and it retries and dead-letters them on its own.
+func (pipeline *Pipeline) RegisterPool(publisher Publisher, options PoolOptions) error {
+	if _, ok := publisher.(DedupPublisher); ok {
+		return fmt.Errorf("%s publisher deduplicates releases for the pipeline, so it can't have a pool", publisher.Name())
+	}
+	if options.Workers <= 0 {
+		return fmt.Errorf("%s publisher's pool needs at least one worker", publisher.Name())
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultPoolQueueSize
+	}
+	switch options.Overflow {
+	case "":
+		options.Overflow = OverflowBlock
+	case OverflowBlock, OverflowDropOldest, OverflowSpill:
+	default:
+		return fmt.Errorf("unknown overflow policy %q, expected block, drop_oldest or spill", options.Overflow)
+	}
+
+	pool := &publisherPool{
+		pipeline:  pipeline,
+		publisher: publisher,
+		workers:   options.Workers,
+		overflow:  options.Overflow,
+		queue:     make(chan publishing, options.QueueSize),
+	}
+	for range options.Workers {
+		pool.running.Add(1)
+		go pool.work()
+	}
+	pipeline.publishers = append(pipeline.publishers, publisher)
+	pipeline.pools = append(pipeline.pools, pool)
+
+	return nil
+}
+
+// The pool publishing to publisher, or nil if it publishes inline.
+func (pipeline *Pipeline) poolFor(publisher Publisher) *publisherPool {
+	for _, pool := range pipeline.pools {
+		if pool.publisher == publisher {
+			return pool
+		}
+	}
+
+	return nil
+}
+
+// Queue a release, applying the overflow policy if the queue is full. Once
+// accepted, the pool finishes the release; otherwise the caller has to.
+func (pool *publisherPool) submit(ctx context.Context, publishing publishing) error {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if pool.closed {
+		return ErrPipelineClosed
+	}
+
+	select {
+	case pool.queue <- publishing:
+		return nil
+	default:
+	}
+
+	fields := log.Fields{"publisher": pool.publisher.Name(), "platform": publishing.Platform, "name": publishing.Name, "version": publishing.Version}
+	switch pool.overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case dropped := <-pool.queue:
+				metrics.PoolOverflows.WithLabelValues(pool.publisher.Name(), string(OverflowDropOldest)).Inc()
+				log.WithFields(fields).WithFields(log.Fields{"dropped": dropped.Platform + "/" + dropped.Name + "@" + dropped.Version}).Error("publisher queue is full, dropped its oldest release")
+				pool.fail(dropped, ErrPoolQueueFull)
+			default:
+			}
+			select {
+			case pool.queue <- publishing:
+				return nil
+			default:
+			}
+		}
+	case OverflowSpill:
+		err := pool.pipeline.spoolRelease(pool.publisher, publishing)
+		if err == nil {
+			metrics.PoolOverflows.WithLabelValues(pool.publisher.Name(), string(OverflowSpill)).Inc()
+			publishing.finish(nil)
+			return nil
+		}
+		log.WithFields(fields).WithFields(log.Fields{"error": err}).Error("publisher queue is full and the release couldn't be spooled, waiting for room")
+	}
+
+	metrics.PoolOverflows.WithLabelValues(pool.publisher.Name(), string(OverflowBlock)).Inc()
+	select {
+	case pool.queue <- publishing:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-pool.pipeline.abort:
+		return ErrPipelineClosed
+	}
+}
+
+// Queue the fresh releases of a batch, recording those that couldn't be.
+func (pool *publisherPool) submitBatch(batch []publishing, fresh []int, results []batchResult) {
+	for _, i := range fresh {
+		publishing := batch[i]
+		// The batch reports the outcome of everything published inline
+		publishing.result = nil
+		results[i].record(pool.submit(context.Background(), publishing))
+	}
+}
+
+func (pool *publisherPool) work() {
+	defer pool.running.Done()
+
+	pipeline := pool.pipeline
+	for {
+		batch, ok := pipeline.nextBatch(pool.queue)
+		if !ok {
+			return
+		}
+
+		select {
+		case <-pipeline.abort:
+			for _, publishing := range batch {
+				pipeline.drop(publishing)
+			}
+			continue
+		default:
+		}
+
+		fresh := make([]int, len(batch))
+		for i := range batch {
+			fresh[i] = i
+		}
+		results := make([]batchResult, len(batch))
+		pipeline.publishBatchTo(pool.publisher, batch, fresh, results)
+
+		for i, publishing := range batch {
+			if results[i].failed {
+				pool.fail(publishing, errors.Join(results[i].errs...))
+				continue
+			}
+			publishing.finish(nil)
+		}
+	}
+}
+
+// Finish a release the pool couldn't publish. As when publishing inline, its
+// dedup key is released so it's published again if an ingestor finds it
+// again, unless the dedup publisher set the key when it enqueued it.
+func (pool *publisherPool) fail(publishing publishing, err error) {
+	if _, hasDedup := pool.pipeline.dedupPublisher(); publishing.only == nil && !hasDedup {
+		pool.pipeline.releaseDedupKey(publishing)
+	}
+	publishing.finish(err)
+}
+
+// Stop accepting releases, and let the workers finish what's queued.
+func (pool *publisherPool) close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if !pool.closed {
+		pool.closed = true
+		close(pool.queue)
+	}
+}
+
+// A pool's workers and how full its queue is, for the status endpoint.
+type PoolStatus struct {
+	Publisher     string         `json:"publisher"`
+	Workers       int            `json:"workers"`
+	Overflow      OverflowPolicy `json:"overflow"`
+	QueueDepth    int            `json:"queue_depth"`
+	QueueCapacity int            `json:"queue_capacity"`
+}
+
+func (pipeline *Pipeline) PoolStatuses() []PoolStatus {
+	statuses := make([]PoolStatus, len(pipeline.pools))
+	for i, pool := range pipeline.pools {
+		statuses[i] = PoolStatus{
+			Publisher:     pool.publisher.Name(),
+			Workers:       pool.workers,
+			Overflow:      pool.overflow,
+			QueueDepth:    len(pool.queue),
+			QueueCapacity: cap(pool.queue),
+		}
+	}
+
+	return statuses
+}
@@ -0,0 +1,163 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Publish one release to a pool whose publisher is held up, and wait until
+// its worker has taken it, so the queue is empty.
+func holdUpPool(t *testing.T, pipeline *Pipeline) {
+	t.Helper()
+
+	if err := pipeline.PublishAll(context.Background(), time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitForEmptyPool(t, pipeline)
+}
+
+func waitForEmptyPool(t *testing.T, pipeline *Pipeline) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for pipeline.PoolStatuses()[0].QueueDepth > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the pool's workers never took the releases")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func publishedVersions(publisher *recordingPublisher) string {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	versions := ""
+	for _, packageVersion := range publisher.published {
+		versions += packageVersion.Version
+	}
+
+	return versions
+}
+
+func TestPipeline_SlowPoolDoesntHoldUpOthers(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	slow := &recordingPublisher{release: make(chan struct{})}
+	fast := &recordingPublisher{}
+	pipeline := newTestPipeline(fast)
+	if err := pipeline.RegisterPool(slow, PoolOptions{Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(3)); err != nil {
+		t.Fatal(err)
+	}
+	if publishedVersions(fast) != "abc" || publishedVersions(slow) != "" {
+		t.Errorf("published %q inline and %q through the pool, want everything inline and nothing yet through the pool", publishedVersions(fast), publishedVersions(slow))
+	}
+
+	close(slow.release)
+	if dropped := pipeline.Close(ctx); len(dropped) != 0 {
+		t.Errorf("dropped %v", dropped)
+	}
+	if publishedVersions(slow) != "abc" {
+		t.Errorf("pool published %q once it caught up, want abc", publishedVersions(slow))
+	}
+}
+
+func TestPipeline_PoolOverflow(t *testing.T) {
+	tests := []struct {
+		overflow OverflowPolicy
+		want     string
+		// Releases whose dedup keys are gone, so they're published when
+		// they're found again
+		released string
+	}{
+		// The first release is being published while the rest arrive, and
+		// two of them fit in the queue. Spilled ones are published once
+		// the spool is drained
+		{overflow: OverflowDropOldest, want: "ade", released: "bc"},
+		{overflow: OverflowSpill, want: "abcde"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.overflow), func(t *testing.T) {
+			server := setupTestRedis(t)
+			ctx := context.Background()
+			spool, err := OpenSpool(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			slow := &recordingPublisher{release: make(chan struct{})}
+			pipeline := NewPipeline()
+			pipeline.SetBatchSize(1)
+			pipeline.SetSpool(spool)
+			if err := pipeline.RegisterPool(slow, PoolOptions{Workers: 1, QueueSize: 2, Overflow: test.overflow}); err != nil {
+				t.Fatal(err)
+			}
+
+			holdUpPool(t, pipeline)
+			if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(5)[1:]); err != nil {
+				t.Fatal(err)
+			}
+
+			close(slow.release)
+			waitForEmptyPool(t, pipeline)
+			if err := pipeline.drainSpool(ctx); err != nil {
+				t.Fatal(err)
+			}
+			pipeline.Close(ctx)
+			if got := publishedVersions(slow); got != test.want {
+				t.Errorf("published %q, want %q", got, test.want)
+			}
+			for _, packageVersion := range testPackageVersions(5) {
+				released := strings.Contains(test.released, packageVersion.Version)
+				if server.Exists((&publishing{PackageVersion: packageVersion}).Key()) == released {
+					t.Errorf("%s's dedup key exists = %t, want %t", packageVersion.Version, !released, !released)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeline_PoolReleasesDedupKeyOnFailure(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	publisher := &recordingPublisher{failures: 1, err: errors.New("timeout")}
+	pipeline := NewPipeline()
+	pipeline.retry = retryPolicy{maxAttempts: 1}
+	if err := pipeline.RegisterPool(publisher, PoolOptions{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Close(ctx)
+
+	if server.Exists((&publishing{PackageVersion: testPackageVersions(1)[0]}).Key()) {
+		t.Error("expected the dedup key to be released so the release is published when it's found again")
+	}
+	if deadLetters, err := pipeline.DeadLetters(ctx); err != nil || len(deadLetters) != 1 {
+		t.Errorf("dead letters = %+v, %v, want the failed release", deadLetters, err)
+	}
+}
+
+func TestPipeline_RegisterPoolRejectsInvalidOptions(t *testing.T) {
+	pipeline := NewPipeline()
+	for _, options := range []PoolOptions{
+		{},
+		{Workers: 1, Overflow: "drop_newest"},
+	} {
+		if err := pipeline.RegisterPool(&recordingPublisher{}, options); err == nil {
+			t.Errorf("expected %+v to be rejected", options)
+		}
+	}
+	if err := pipeline.RegisterPool(NewSidekiq(), PoolOptions{Workers: 1}); err == nil {
+		t.Error("expected the dedup publisher to be rejected")
+	}
+}
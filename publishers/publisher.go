@@ -1,7 +1,41 @@
 package publishers
 
-import "github.com/librariesio/depper/data"
+import (
+	"context"
+	"time"
+
+	"github.com/librariesio/depper/data"
+)
 
 type Publisher interface {
-	Publish(data.PackageVersion)
+	// Identifies the publisher in logs and dead letters
+	Name() string
+	// Errors are retried by the pipeline unless wrapped with Permanent
+	Publish(data.PackageVersion) error
+}
+
+// A publisher that can publish a whole batch of releases at once, e.g. in a
+// single round trip. If it returns an error the pipeline falls back to
+// publishing the batch one release at a time.
+type BatchPublisher interface {
+	Publisher
+	PublishBatch([]data.PackageVersion) error
+}
+
+// A release along with the dedup key that marks it as published.
+type DedupRelease struct {
+	Key            string
+	TTL            time.Duration
+	PackageVersion data.PackageVersion
+}
+
+// A publisher that can check and set the pipeline's dedup key atomically
+// with publishing. The pipeline lets the first one it has decide whether a
+// release is new, instead of setting the key itself beforehand.
+type DedupPublisher interface {
+	Publisher
+	// Publish each release unless its key exists, and set the key to expire
+	// after its TTL if it was published. Returns, for each release, whether
+	// it was published and the error that stopped it if it failed.
+	PublishOnce(ctx context.Context, releases []DedupRelease) ([]bool, []error)
 }
@@ -7,11 +7,45 @@ import (
 	"github.com/librariesio/depper/data"
 )
 
+// Dedup keys need an expiry, or a release would never be published again,
+// and Redis rejects expiries under a millisecond.
+func ValidateTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("ttl must be at least 1ms, got %s", ttl)
+	}
+
+	return nil
+}
+
 type publishing struct {
 	data.PackageVersion
 	ttl time.Duration
+	// Publish even if the dedup key says it was published within ttl
+	force bool
+	// Publish only to this publisher, skipping the dedup check, e.g. when
+	// replaying a dead letter
+	only Publisher
+	// Receives the outcome of processing, if anyone is waiting for it
+	result chan<- error
+}
+
+func (p *publishing) finish(err error) {
+	if p.result != nil {
+		p.result <- err
+	}
 }
 
+// Releases whose action is distinct, like a yank, get a key of their own, so
+// they aren't skipped as repeats of the release.
 func (p *publishing) Key() string {
-	return fmt.Sprintf("depper:ingest:%s:%s:%s", p.Platform, p.Name, p.Version)
+	key := fmt.Sprintf("depper:ingest:%s:%s:%s", p.Platform, p.Name, p.Version)
+	if p.Action.IsDistinct() {
+		key += ":" + string(p.Action)
+	}
+
+	return key
+}
+
+func (p *publishing) dedupRelease() DedupRelease {
+	return DedupRelease{Key: p.Key(), TTL: p.ttl, PackageVersion: p.PackageVersion}
 }
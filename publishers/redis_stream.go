@@ -0,0 +1,93 @@
+package publishers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/redis"
+)
+
+const (
+	defaultStreamKey    = "depper:releases"
+	defaultStreamMaxLen = 1_000_000
+)
+
+type RedisStreamOptions struct {
+	// Defaults to depper:releases
+	Stream string `yaml:"stream"`
+	// Roughly how many entries to keep, trimmed with MAXLEN ~. Defaults to
+	// a million
+	MaxLen int64 `yaml:"max_len"`
+}
+
+// Adds each release to a Redis stream, which any number of consumer groups
+// can read from at their own pace.
+type RedisStream struct {
+	stream string
+	maxLen int64
+}
+
+func NewRedisStream(options RedisStreamOptions) *RedisStream {
+	stream := &RedisStream{stream: options.Stream, maxLen: options.MaxLen}
+	if stream.stream == "" {
+		stream.stream = defaultStreamKey
+	}
+	if stream.maxLen <= 0 {
+		stream.maxLen = defaultStreamMaxLen
+	}
+
+	return stream
+}
+
+func (stream *RedisStream) Name() string {
+	return "redis_stream"
+}
+
+func (stream *RedisStream) Publish(packageVersion data.PackageVersion) error {
+	return redis.Client.XAdd(context.Background(), stream.xAddArgs(packageVersion)).Err()
+}
+
+// Add every release in one round trip.
+func (stream *RedisStream) PublishBatch(packageVersions []data.PackageVersion) error {
+	_, err := redis.Client.Pipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		for _, packageVersion := range packageVersions {
+			pipe.XAdd(context.Background(), stream.xAddArgs(packageVersion))
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (stream *RedisStream) xAddArgs(packageVersion data.PackageVersion) *goredis.XAddArgs {
+	return &goredis.XAddArgs{
+		Stream: stream.stream,
+		MaxLen: stream.maxLen,
+		Approx: true,
+		Values: streamFields(packageVersion),
+	}
+}
+
+// Every entry has all of these fields, empty when unknown, so consumers can
+// rely on the schema.
+func streamFields(packageVersion data.PackageVersion) []interface{} {
+	var createdAt string
+	if !packageVersion.CreatedAt.IsZero() {
+		createdAt = packageVersion.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return []interface{}{
+		"platform", packageVersion.Platform,
+		"name", packageVersion.Name,
+		"version", packageVersion.Version,
+		"created_at", createdAt,
+		"discovery_lag_ms", strconv.FormatInt(packageVersion.DiscoveryLag.Milliseconds(), 10),
+		"sequence", packageVersion.Sequence,
+		"ingestor", packageVersion.Ingestor,
+		"action", string(packageVersion.Action),
+	}
+}
@@ -0,0 +1,105 @@
+package publishers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/redis"
+)
+
+func TestRedisStream_Publish(t *testing.T) {
+	server := setupTestRedis(t)
+	stream := NewRedisStream(RedisStreamOptions{})
+	packageVersion := data.PackageVersion{
+		Platform:     "npm",
+		Name:         "left-pad",
+		Version:      "1.3.0",
+		CreatedAt:    time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		DiscoveryLag: 1500 * time.Millisecond,
+		Sequence:     "42",
+		Ingestor:     "npm",
+		Action:       data.ActionNew,
+	}
+
+	if err := stream.Publish(packageVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.PublishBatch([]data.PackageVersion{{Platform: "pypi", Name: "requests", Version: "2.32.0"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := server.Stream("depper:releases")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("stream = %v, %v, want 2 entries", entries, err)
+	}
+	want := []string{
+		"platform", "npm",
+		"name", "left-pad",
+		"version", "1.3.0",
+		"created_at", "2024-05-06T07:08:09Z",
+		"discovery_lag_ms", "1500",
+		"sequence", "42",
+		"ingestor", "npm",
+		"action", "new",
+	}
+	if got := entries[0].Values; len(got) != len(want) {
+		t.Fatalf("fields = %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("fields = %v, want %v", got, want)
+				break
+			}
+		}
+	}
+	// Unknown fields are still there, just empty
+	if got := entries[1].Values; len(got) != len(want) || got[7] != "" {
+		t.Errorf("fields = %v, want every field", got)
+	}
+}
+
+func TestRedisStream_ConsumerGroupsReadIndependently(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	stream := NewRedisStream(RedisStreamOptions{Stream: "releases"})
+	for _, group := range []string{"search", "analytics"} {
+		if err := redis.Client.XGroupCreateMkStream(ctx, "releases", group, "0").Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := stream.PublishBatch(testPackageVersions(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, group := range []string{"search", "analytics"} {
+		streams, err := redis.Client.XReadGroup(ctx, &goredis.XReadGroupArgs{
+			Group:    group,
+			Consumer: "worker",
+			Streams:  []string{"releases", ">"},
+			Count:    10,
+		}).Result()
+		if err != nil || len(streams) != 1 || len(streams[0].Messages) != 3 {
+			t.Errorf("%s read %v, %v, want all 3 releases", group, streams, err)
+		}
+	}
+}
+
+func TestRedisStream_TrimsToMaxLen(t *testing.T) {
+	server := setupTestRedis(t)
+	stream := NewRedisStream(RedisStreamOptions{MaxLen: 3})
+
+	if err := stream.PublishBatch(testPackageVersions(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis itself trims approximately, leaving at least MaxLen entries, but
+	// miniredis trims exactly
+	if entries, _ := server.Stream("depper:releases"); len(entries) != 3 || entries[0].Values[5] != "c" {
+		t.Errorf("stream = %v, want the last 3 releases", entries)
+	}
+}
@@ -0,0 +1,47 @@
+package publishers
+
+import (
+	"errors"
+	"time"
+)
+
+// How the pipeline retries a publisher that returned an error
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts:    5,
+	initialBackoff: 100 * time.Millisecond,
+	maxBackoff:     5 * time.Second,
+}
+
+// How long to wait before the given retry, doubling each time.
+func (policy retryPolicy) backoff(retry int) time.Duration {
+	backoff := policy.initialBackoff << (retry - 1)
+	if backoff <= 0 || backoff > policy.maxBackoff {
+		return policy.maxBackoff
+	}
+
+	return backoff
+}
+
+type permanentError struct {
+	err error
+}
+
+func (err *permanentError) Error() string { return err.err.Error() }
+func (err *permanentError) Unwrap() error { return err.err }
+
+// Wrap an error that retrying won't fix, e.g. a release that can't be
+// encoded, so the pipeline dead-letters it straight away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
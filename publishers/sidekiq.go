@@ -7,28 +7,53 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/librariesio/depper/data"
 	"github.com/librariesio/depper/redis"
 )
 
-type Sidekiq struct{}
+// Sidekiq's set of every queue name, which its web UI and stats read from
+const sidekiqQueuesKey = "queues"
+
+// Sidekiq's sorted set of jobs to enqueue later, scored by when
+const sidekiqScheduleKey = "schedule"
+
+type Sidekiq struct {
+	routing SidekiqRouting
+}
 
 type LibrariesJob struct {
-	Class      string   `json:"class"`
-	Queue      string   `json:"queue"`
-	Args       []string `json:"args"`
-	Retry      bool     `json:"retry"`
-	JID        string   `json:"jid"`
-	CreatedAt  int64    `json:"created_at"`
-	EnqueuedAt int64    `json:"enqueued_at"`
+	Class string `json:"class"`
+	Queue string `json:"queue"`
+	Args  []any  `json:"args"`
+	// true, false or a number of retries
+	Retry     any    `json:"retry"`
+	JID       string `json:"jid"`
+	CreatedAt int64  `json:"created_at"`
+	// Left out of scheduled jobs until Sidekiq enqueues them
+	EnqueuedAt int64 `json:"enqueued_at,omitempty"`
 }
 
+// Enqueues every release for PackageManagerDownloadWorker on the critical
+// queue.
 func NewSidekiq() *Sidekiq {
-	return &Sidekiq{}
+	return &Sidekiq{routing: SidekiqRouting{SidekiqRoute: defaultSidekiqRoute}}
+}
+
+// Enqueues each release for the worker and queue its routing picks.
+func NewRoutedSidekiq(routing SidekiqRouting) (*Sidekiq, error) {
+	resolved, err := routing.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sidekiq{routing: resolved}, nil
 }
 
 func randomHex(n int) string {
@@ -40,26 +65,241 @@ func randomHex(n int) string {
 	return hex.EncodeToString(id)
 }
 
-func createSyncJob(packageVersion data.PackageVersion) *LibrariesJob {
-	return &LibrariesJob{
-		Retry:      true,
-		Class:      "PackageManagerDownloadWorker",
-		Queue:      "critical",
-		JID:        randomHex(12),
-		EnqueuedAt: time.Now().Unix(),
-		CreatedAt:  time.Now().Unix(),
-		Args:       []string{packageVersion.Platform, packageVersion.Name, packageVersion.Version},
+func (lib *Sidekiq) createSyncJob(packageVersion data.PackageVersion) *LibrariesJob {
+	route := lib.routing.route(packageVersion)
+	args := []any{packageVersion.Platform, packageVersion.Name, packageVersion.Version}
+
+	job := &LibrariesJob{
+		Retry:     route.Retry,
+		Class:     route.Class,
+		Queue:     route.Queue,
+		JID:       randomHex(12),
+		CreatedAt: time.Now().Unix(),
+		Args:      append(args, route.Args...),
+	}
+	if packageVersion.Delay <= 0 {
+		job.EnqueuedAt = job.CreatedAt
+	}
+
+	return job
+}
+
+// When a delayed release's job should run, as a score in Sidekiq's schedule
+// set. Zero means enqueue it now.
+func scheduledAt(packageVersion data.PackageVersion) float64 {
+	if packageVersion.Delay <= 0 {
+		return 0
 	}
+
+	return float64(time.Now().Add(packageVersion.Delay).UnixMilli()) / 1000
 }
 
-func (lib *Sidekiq) Publish(packageVersion data.PackageVersion) {
-	job := createSyncJob(packageVersion)
+func (lib *Sidekiq) Name() string {
+	return "sidekiq"
+}
+
+// Enqueue the job and register its queue in Sidekiq's "queues" set in one
+// MULTI, as Sidekiq's own client does. Delayed releases are added to the
+// schedule set instead, and Sidekiq enqueues them once they are due.
+func (lib *Sidekiq) Publish(packageVersion data.PackageVersion) error {
+	job := lib.createSyncJob(packageVersion)
 	encoded, err := json.Marshal(job)
 	if err != nil {
-		log.WithFields(log.Fields{"publisher": "sidekiq"}).Error(err)
-		return
+		return Permanent(err)
 	}
-	if err := redis.Client.LPush(context.Background(), fmt.Sprintf("queue:%s", job.Queue), string(encoded)).Err(); err != nil {
-		log.WithFields(log.Fields{"publisher": "sidekiq"}).Error(err)
+
+	_, err = redis.Queue().TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		if at := scheduledAt(packageVersion); at > 0 {
+			pipe.ZAdd(context.Background(), sidekiqScheduleKey, &goredis.Z{Score: at, Member: string(encoded)})
+			return nil
+		}
+		pipe.LPush(context.Background(), queueKey(job.Queue), string(encoded))
+		pipe.SAdd(context.Background(), sidekiqQueuesKey, job.Queue)
+		return nil
+	})
+
+	return err
+}
+
+// Does the dedup check, the enqueue and the queue registration in a single
+// script, so the dedup key exists if and only if the job was enqueued. A
+// script doesn't undo what it already wrote when a command fails, so the TTL,
+// which SET rejects unless it's positive, is checked before anything is
+// written. Jobs with a time in ARGV[4] are scheduled instead of enqueued.
+var publishOnceScript = goredis.NewScript(`
+local ttl = tonumber(ARGV[1])
+if not ttl or ttl <= 0 then
+	return redis.error_reply("ERR invalid ttl " .. ARGV[1])
+end
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+if ARGV[4] == "0" then
+	redis.call("LPUSH", KEYS[2], ARGV[2])
+	redis.call("SADD", KEYS[3], ARGV[3])
+else
+	redis.call("ZADD", KEYS[4], ARGV[4], ARGV[2])
+end
+redis.call("SET", KEYS[1], "1", "PX", ARGV[1])
+return 1
+`)
+
+// Run the dedup-and-enqueue script for every release in one round trip.
+// Each script runs on its own, so one failing doesn't undo the others.
+func (lib *Sidekiq) PublishOnce(ctx context.Context, releases []DedupRelease) ([]bool, []error) {
+	if !redis.Colocated(redis.Dedup(), redis.Queue()) {
+		return lib.publishOnceApart(ctx, releases)
 	}
+
+	published := make([]bool, len(releases))
+	errs := make([]error, len(releases))
+
+	type call struct {
+		index int
+		keys  []string
+		args  []interface{}
+	}
+	calls := make([]call, 0, len(releases))
+	for i, release := range releases {
+		if err := ValidateTTL(release.TTL); err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		job := lib.createSyncJob(release.PackageVersion)
+		encoded, err := json.Marshal(job)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		calls = append(calls, call{
+			index: i,
+			keys:  []string{release.Key, queueKey(job.Queue), sidekiqQueuesKey, sidekiqScheduleKey},
+			args: []interface{}{
+				release.TTL.Milliseconds(), string(encoded), job.Queue,
+				strconv.FormatFloat(scheduledAt(release.PackageVersion), 'f', -1, 64),
+			},
+		})
+	}
+
+	for attempt := 0; len(calls) > 0; attempt++ {
+		cmds := make([]*goredis.Cmd, len(calls))
+		// Per-command errors are read below.
+		_, _ = redis.Dedup().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for i, call := range calls {
+				cmds[i] = publishOnceScript.EvalSha(ctx, pipe, call.keys, call.args...)
+			}
+			return nil
+		})
+
+		// Scripts that failed with NOSCRIPT didn't run, so load the script
+		// and run just those again.
+		var unloaded []call
+		for i, cmd := range cmds {
+			result, err := cmd.Int()
+			if err != nil && attempt == 0 && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+				unloaded = append(unloaded, calls[i])
+				continue
+			}
+			published[calls[i].index], errs[calls[i].index] = result == 1, err
+		}
+		if len(unloaded) > 0 {
+			if err := publishOnceScript.Load(ctx, redis.Dedup()).Err(); err != nil {
+				for _, call := range unloaded {
+					errs[call.index] = err
+				}
+				break
+			}
+		}
+		calls = unloaded
+	}
+
+	return published, errs
+}
+
+// Without a script that can see both the dedup keys and the queue, claim the
+// keys with SETNX first, then enqueue the releases that were claimed. If the
+// enqueue fails the keys are deleted again, so the releases can be retried.
+func (lib *Sidekiq) publishOnceApart(ctx context.Context, releases []DedupRelease) ([]bool, []error) {
+	published := make([]bool, len(releases))
+	errs := make([]error, len(releases))
+
+	cmds := make([]*goredis.BoolCmd, len(releases))
+	// Per-command errors are read below.
+	_, _ = redis.Dedup().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, release := range releases {
+			// Without an expiry SETNX would keep the key forever
+			if err := ValidateTTL(release.TTL); err != nil {
+				errs[i] = Permanent(err)
+				continue
+			}
+			cmds[i] = pipe.SetNX(ctx, release.Key, "1", release.TTL)
+		}
+		return nil
+	})
+
+	var claimed []int
+	var packageVersions []data.PackageVersion
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		if errs[i] = cmd.Err(); errs[i] == nil && cmd.Val() {
+			claimed = append(claimed, i)
+			packageVersions = append(packageVersions, releases[i].PackageVersion)
+		}
+	}
+	if len(claimed) == 0 {
+		return published, errs
+	}
+
+	if err := lib.PublishBatch(packageVersions); err != nil {
+		for _, i := range claimed {
+			errs[i] = err
+			if err := redis.Dedup().Del(ctx, releases[i].Key).Err(); err != nil {
+				log.WithFields(log.Fields{"publisher": lib.Name(), "key": releases[i].Key, "error": err}).Error("couldn't release dedup key")
+			}
+		}
+		return published, errs
+	}
+	for _, i := range claimed {
+		published[i] = true
+	}
+
+	return published, errs
+}
+
+// Enqueue every job with a single LPUSH per queue, and schedule the delayed
+// ones with a single ZADD, in one MULTI.
+func (lib *Sidekiq) PublishBatch(packageVersions []data.PackageVersion) error {
+	jobs := make(map[string][]interface{})
+	var scheduled []*goredis.Z
+	for _, packageVersion := range packageVersions {
+		job := lib.createSyncJob(packageVersion)
+		encoded, err := json.Marshal(job)
+		if err != nil {
+			return Permanent(err)
+		}
+		if at := scheduledAt(packageVersion); at > 0 {
+			scheduled = append(scheduled, &goredis.Z{Score: at, Member: string(encoded)})
+			continue
+		}
+		jobs[job.Queue] = append(jobs[job.Queue], string(encoded))
+	}
+
+	_, err := redis.Queue().TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		for queue, encoded := range jobs {
+			pipe.LPush(context.Background(), queueKey(queue), encoded...)
+			pipe.SAdd(context.Background(), sidekiqQueuesKey, queue)
+		}
+		if len(scheduled) > 0 {
+			pipe.ZAdd(context.Background(), sidekiqScheduleKey, scheduled...)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func queueKey(queue string) string {
+	return fmt.Sprintf("queue:%s", queue)
 }
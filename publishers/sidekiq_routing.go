@@ -0,0 +1,145 @@
+package publishers
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/librariesio/depper/data"
+)
+
+// The kinds of release a Sidekiq rule can match on.
+const (
+	// A release with a version number
+	EventVersion = "version"
+	// Only the package's name is known, e.g. from npm's changes feed
+	EventNameOnly = "name_only"
+)
+
+// Which worker a release's job is sent to. Empty fields fall back to the
+// routing's defaults.
+type SidekiqRoute struct {
+	Class string `yaml:"class"`
+	Queue string `yaml:"queue"`
+	// true, false or the number of times Sidekiq retries the job
+	Retry any `yaml:"retry"`
+	// Passed to the worker after the platform, name and version
+	Args []any `yaml:"args"`
+}
+
+// Routes the releases of the given platforms, events and actions. Empty
+// lists match everything. Releases without an action only match rules
+// without actions.
+type SidekiqRule struct {
+	Platforms    []string      `yaml:"platforms"`
+	Events       []string      `yaml:"events"`
+	Actions      []data.Action `yaml:"actions"`
+	SidekiqRoute `yaml:",inline"`
+}
+
+// The first rule that matches a release picks its route, and releases no
+// rule matches use the default route.
+type SidekiqRouting struct {
+	SidekiqRoute `yaml:",inline"`
+	Rules        []SidekiqRule `yaml:"rules"`
+}
+
+// Where every release went before routing was configurable.
+var defaultSidekiqRoute = SidekiqRoute{
+	Class: "PackageManagerDownloadWorker",
+	Queue: "critical",
+	Retry: true,
+}
+
+// The kind of release packageVersion is, for matching against rules.
+func releaseEvent(packageVersion data.PackageVersion) string {
+	if packageVersion.Version == "" {
+		return EventNameOnly
+	}
+
+	return EventVersion
+}
+
+func (rule SidekiqRule) matches(packageVersion data.PackageVersion) bool {
+	if len(rule.Platforms) > 0 && !slices.Contains(rule.Platforms, packageVersion.Platform) {
+		return false
+	}
+	if len(rule.Events) > 0 && !slices.Contains(rule.Events, releaseEvent(packageVersion)) {
+		return false
+	}
+	if len(rule.Actions) > 0 && !slices.Contains(rule.Actions, packageVersion.Action) {
+		return false
+	}
+
+	return true
+}
+
+// Fill in route's empty fields from defaults.
+func (route SidekiqRoute) withDefaults(defaults SidekiqRoute) SidekiqRoute {
+	if route.Class == "" {
+		route.Class = defaults.Class
+	}
+	if route.Queue == "" {
+		route.Queue = defaults.Queue
+	}
+	if route.Retry == nil {
+		route.Retry = defaults.Retry
+	}
+	if route.Args == nil {
+		route.Args = defaults.Args
+	}
+
+	return route
+}
+
+func (route SidekiqRoute) validate() error {
+	switch retry := route.Retry.(type) {
+	case bool:
+	case int:
+		if retry < 0 {
+			return fmt.Errorf("retry must not be negative, got %d", retry)
+		}
+	default:
+		return fmt.Errorf("retry must be true, false or a number of retries, got %v", retry)
+	}
+
+	return nil
+}
+
+// Fill in every rule from the defaults so that routing a release is just
+// finding the first match, and check the result is a valid job.
+func (routing SidekiqRouting) resolve() (SidekiqRouting, error) {
+	resolved := SidekiqRouting{SidekiqRoute: routing.SidekiqRoute.withDefaults(defaultSidekiqRoute)}
+	if err := resolved.SidekiqRoute.validate(); err != nil {
+		return SidekiqRouting{}, fmt.Errorf("default route: %w", err)
+	}
+
+	for i, rule := range routing.Rules {
+		for _, event := range rule.Events {
+			if event != EventVersion && event != EventNameOnly {
+				return SidekiqRouting{}, fmt.Errorf("rule %d: unknown event %q", i, event)
+			}
+		}
+		for _, action := range rule.Actions {
+			if !action.IsValid() {
+				return SidekiqRouting{}, fmt.Errorf("rule %d: unknown action %q", i, action)
+			}
+		}
+		rule.SidekiqRoute = rule.SidekiqRoute.withDefaults(resolved.SidekiqRoute)
+		if err := rule.SidekiqRoute.validate(); err != nil {
+			return SidekiqRouting{}, fmt.Errorf("rule %d: %w", i, err)
+		}
+		resolved.Rules = append(resolved.Rules, rule)
+	}
+
+	return resolved, nil
+}
+
+func (routing SidekiqRouting) route(packageVersion data.PackageVersion) SidekiqRoute {
+	for _, rule := range routing.Rules {
+		if rule.matches(packageVersion) {
+			return rule.SidekiqRoute
+		}
+	}
+
+	return routing.SidekiqRoute
+}
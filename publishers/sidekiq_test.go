@@ -0,0 +1,314 @@
+package publishers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/redis"
+)
+
+func TestSidekiq_PublishOnce(t *testing.T) {
+	server := setupTestRedis(t)
+	sidekiq := NewSidekiq()
+	packageVersion := data.PackageVersion{Platform: "npm", Name: "left-pad", Version: "1.3.0"}
+	key := (&publishing{PackageVersion: packageVersion}).Key()
+	releases := []DedupRelease{{Key: key, TTL: time.Hour, PackageVersion: packageVersion}}
+
+	published, errs := sidekiq.PublishOnce(context.Background(), releases)
+	if errs[0] != nil || !published[0] {
+		t.Fatalf("first PublishOnce = %v, %v", published, errs)
+	}
+	published, errs = sidekiq.PublishOnce(context.Background(), releases)
+	if errs[0] != nil || published[0] {
+		t.Fatalf("second PublishOnce = %v, %v", published, errs)
+	}
+
+	jobs, _ := server.List("queue:critical")
+	if len(jobs) != 1 {
+		t.Fatalf("enqueued %d jobs, want 1", len(jobs))
+	}
+	var job LibrariesJob
+	if err := json.Unmarshal([]byte(jobs[0]), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Class != "PackageManagerDownloadWorker" || len(job.Args) != 3 || job.Args[1] != "left-pad" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if queues, _ := server.Members("queues"); len(queues) != 1 || queues[0] != "critical" {
+		t.Errorf("queues = %v, want [critical]", queues)
+	}
+	if ttl := server.TTL(key); ttl != time.Hour {
+		t.Errorf("dedup key ttl = %s, want 1h", ttl)
+	}
+}
+
+func TestSidekiq_PublishOnceWithQueueOnAnotherServer(t *testing.T) {
+	dedupServer := setupTestRedis(t)
+	queueServer := miniredis.RunT(t)
+	redis.QueueClient = goredis.NewClient(&goredis.Options{Addr: queueServer.Addr()})
+	t.Cleanup(func() {
+		redis.QueueClient.Close()
+		redis.QueueClient = nil
+	})
+	ctx := context.Background()
+	sidekiq := NewSidekiq()
+	releases := make([]DedupRelease, 2)
+	for i, packageVersion := range testPackageVersions(2) {
+		releases[i] = DedupRelease{Key: (&publishing{PackageVersion: packageVersion}).Key(), TTL: time.Hour, PackageVersion: packageVersion}
+	}
+
+	published, errs := sidekiq.PublishOnce(ctx, releases[:1])
+	if errs[0] != nil || !published[0] {
+		t.Fatalf("first PublishOnce = %v, %v", published, errs)
+	}
+	published, errs = sidekiq.PublishOnce(ctx, releases[:1])
+	if errs[0] != nil || published[0] {
+		t.Fatalf("second PublishOnce = %v, %v, want it deduplicated", published, errs)
+	}
+	if jobs, _ := queueServer.List("queue:critical"); len(jobs) != 1 {
+		t.Errorf("queue server has %d jobs, want 1", len(jobs))
+	}
+	if !dedupServer.Exists(releases[0].Key) || dedupServer.Exists("queue:critical") {
+		t.Error("expected the dedup key, and only the dedup key, on the dedup server")
+	}
+
+	// A release that can't be enqueued doesn't keep its key
+	queueServer.Close()
+	published, errs = sidekiq.PublishOnce(ctx, releases[1:])
+	if errs[0] == nil || published[0] {
+		t.Errorf("PublishOnce with the queue down = %v, %v, want an error", published, errs)
+	}
+	if dedupServer.Exists(releases[1].Key) {
+		t.Error("expected the key of a release that wasn't enqueued to be deleted")
+	}
+}
+
+func TestSidekiq_PublishOnceRejectsTTLsWithoutExpiry(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	packageVersion := testPackageVersions(1)[0]
+	key := (&publishing{PackageVersion: packageVersion}).Key()
+
+	for _, ttl := range []time.Duration{0, -time.Hour, time.Microsecond} {
+		published, errs := NewSidekiq().PublishOnce(ctx, []DedupRelease{{Key: key, TTL: ttl, PackageVersion: packageVersion}})
+		if errs[0] == nil || !isPermanent(errs[0]) || published[0] {
+			t.Errorf("PublishOnce with a ttl of %s = %v, %v, want a permanent error", ttl, published, errs)
+		}
+	}
+
+	// Nor does the script write anything before rejecting one
+	err := publishOnceScript.Run(ctx, redis.Client, []string{key, "queue:critical", sidekiqQueuesKey, sidekiqScheduleKey}, 0, "{}", "critical", "0").Err()
+	if err == nil {
+		t.Error("expected the script to reject a ttl of 0")
+	}
+	if server.Exists(key) || server.Exists("queue:critical") {
+		t.Error("expected neither a dedup key nor a job")
+	}
+}
+
+func TestPipeline_DedupsThroughSidekiq(t *testing.T) {
+	server := setupTestRedis(t)
+	logged := &recordingPublisher{}
+	pipeline := newTestPipeline(logged)
+	pipeline.Register(NewSidekiq())
+	packageVersions := testPackageVersions(2)
+
+	for range 2 {
+		if err := pipeline.PublishAll(context.Background(), time.Hour, packageVersions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 2 {
+		t.Errorf("enqueued %d jobs, want 2", len(jobs))
+	}
+	if len(logged.published) != 2 {
+		t.Errorf("other publishers got %d releases, want 2", len(logged.published))
+	}
+}
+
+func TestPipeline_KeepsSidekiqKeyWhenAnotherPublisherFails(t *testing.T) {
+	server := setupTestRedis(t)
+	failing := &recordingPublisher{failures: 1, err: Permanent(errors.New("bad release"))}
+	pipeline := newTestPipeline(NewSidekiq())
+	pipeline.Register(failing)
+	packageVersion := testPackageVersions(1)[0]
+
+	if err := pipeline.PublishAll(context.Background(), time.Hour, []data.PackageVersion{packageVersion}); err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 1 {
+		t.Fatalf("enqueued %d jobs, want 1", len(jobs))
+	}
+	if !server.Exists((&publishing{PackageVersion: packageVersion}).Key()) {
+		t.Error("the dedup key of an enqueued job was released")
+	}
+}
+
+func TestPipeline_ReplaysSidekiqDeadLettersWithDedup(t *testing.T) {
+	server := setupTestRedis(t)
+	sidekiq := NewSidekiq()
+	pipeline := newTestPipeline(sidekiq)
+	packageVersion := testPackageVersions(1)[0]
+	key := (&publishing{PackageVersion: packageVersion}).Key()
+
+	failed := publishing{PackageVersion: packageVersion, ttl: time.Hour}
+	for range 2 {
+		if err := pipeline.deadLetter(sidekiq, failed, 5, errors.New("connection refused")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replayed, err := pipeline.ReplayDeadLetters(context.Background())
+	if err != nil || replayed != 2 {
+		t.Fatalf("replay = %d, %v", replayed, err)
+	}
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 1 {
+		t.Errorf("enqueued %d jobs, want 1", len(jobs))
+	}
+	if ttl := server.TTL(key); ttl != time.Hour {
+		t.Errorf("dedup key ttl = %s, want 1h", ttl)
+	}
+}
+
+func TestSidekiq_Routing(t *testing.T) {
+	server := setupTestRedis(t)
+	sidekiq, err := NewRoutedSidekiq(SidekiqRouting{
+		Rules: []SidekiqRule{
+			{
+				Actions:      []data.Action{data.ActionYank, data.ActionDelete},
+				SidekiqRoute: SidekiqRoute{Class: "PackageManagerRemovalWorker", Queue: "removals"},
+			},
+			{
+				Platforms:    []string{"npm"},
+				Events:       []string{EventNameOnly},
+				SidekiqRoute: SidekiqRoute{Queue: "default", Retry: 3},
+			},
+			{
+				Platforms:    []string{"maven"},
+				SidekiqRoute: SidekiqRoute{Class: "MavenDownloadWorker", Args: []any{"priority"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		packageVersion data.PackageVersion
+		wantQueue      string
+		wantClass      string
+		wantRetry      any
+		wantArgs       int
+	}{
+		{
+			name:           "name only npm",
+			packageVersion: data.PackageVersion{Platform: "npm", Name: "left-pad"},
+			wantQueue:      "default",
+			wantClass:      "PackageManagerDownloadWorker",
+			wantRetry:      float64(3),
+			wantArgs:       3,
+		},
+		{
+			name:           "npm with a version",
+			packageVersion: data.PackageVersion{Platform: "npm", Name: "left-pad", Version: "1.3.0"},
+			wantQueue:      "critical",
+			wantClass:      "PackageManagerDownloadWorker",
+			wantRetry:      true,
+			wantArgs:       3,
+		},
+		{
+			name:           "deleted npm package",
+			packageVersion: data.PackageVersion{Platform: "npm", Name: "left-pad", Action: data.ActionDelete},
+			wantQueue:      "removals",
+			wantClass:      "PackageManagerRemovalWorker",
+			wantRetry:      true,
+			wantArgs:       3,
+		},
+		{
+			name:           "new maven release",
+			packageVersion: data.PackageVersion{Platform: "maven", Name: "junit:junit", Version: "4.13.2", Action: data.ActionNew},
+			wantQueue:      "critical",
+			wantClass:      "MavenDownloadWorker",
+			wantRetry:      true,
+			wantArgs:       4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server.FlushAll()
+			if err := sidekiq.PublishBatch([]data.PackageVersion{test.packageVersion}); err != nil {
+				t.Fatal(err)
+			}
+
+			jobs, _ := server.List("queue:" + test.wantQueue)
+			if len(jobs) != 1 {
+				t.Fatalf("enqueued %d jobs on %s, want 1", len(jobs), test.wantQueue)
+			}
+			var job LibrariesJob
+			if err := json.Unmarshal([]byte(jobs[0]), &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Class != test.wantClass || job.Retry != test.wantRetry || len(job.Args) != test.wantArgs {
+				t.Errorf("unexpected job %+v", job)
+			}
+		})
+	}
+}
+
+func TestNewRoutedSidekiq_RejectsInvalidRules(t *testing.T) {
+	for _, routing := range []SidekiqRouting{
+		{SidekiqRoute: SidekiqRoute{Retry: "sometimes"}},
+		{Rules: []SidekiqRule{{SidekiqRoute: SidekiqRoute{Retry: -1}}}},
+		{Rules: []SidekiqRule{{Events: []string{"yanked"}}}},
+		{Rules: []SidekiqRule{{Actions: []data.Action{"yanked"}}}},
+	} {
+		if _, err := NewRoutedSidekiq(routing); err == nil {
+			t.Errorf("expected %+v to be rejected", routing)
+		}
+	}
+}
+
+func TestSidekiq_SchedulesDelayedReleases(t *testing.T) {
+	server := setupTestRedis(t)
+	sidekiq := NewSidekiq()
+	delayed := data.PackageVersion{Platform: "conda", Name: "numpy", Version: "2.0.0", Delay: 5 * time.Minute}
+	immediate := data.PackageVersion{Platform: "pypi", Name: "requests", Version: "2.32.0"}
+
+	if err := sidekiq.Publish(delayed); err != nil {
+		t.Fatal(err)
+	}
+	if err := sidekiq.PublishBatch([]data.PackageVersion{delayed, immediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 1 {
+		t.Errorf("enqueued %d jobs, want only the immediate one", len(jobs))
+	}
+	scheduled, _ := server.ZMembers("schedule")
+	if len(scheduled) != 2 {
+		t.Fatalf("scheduled %d jobs, want 2", len(scheduled))
+	}
+	for _, encoded := range scheduled {
+		var job LibrariesJob
+		if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Queue != "critical" || job.EnqueuedAt != 0 {
+			t.Errorf("unexpected scheduled job %+v", job)
+		}
+		if at, _ := server.ZScore("schedule", encoded); time.Until(time.Unix(int64(at), 0)) < 4*time.Minute {
+			t.Errorf("job is due at %v, want in 5 minutes", at)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package publishers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/metrics"
+	"github.com/librariesio/depper/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// Segments are rotated once they reach this size
+const defaultSpoolSegmentBytes = 4 << 20
+
+const spoolSegmentSuffix = ".spool"
+
+// How often DrainSpool checks for Redis being back, unless told otherwise
+const defaultSpoolDrainInterval = 5 * time.Second
+
+// A release the pipeline couldn't get into Redis, written ahead to disk.
+type spoolEntry struct {
+	// Publish only to this publisher. Empty goes through the dedup check
+	// and every publisher
+	Publisher      string              `json:"publisher,omitempty"`
+	PackageVersion data.PackageVersion `json:"package_version"`
+	TTLSeconds     int64               `json:"ttl_seconds"`
+	SpooledAt      time.Time           `json:"spooled_at"`
+}
+
+// An on-disk write-ahead log of releases, kept as numbered append-only
+// segment files of JSON lines. Entries are appended to the newest segment,
+// and read back a whole segment at a time, oldest first.
+type Spool struct {
+	dir             string
+	maxSegmentBytes int64
+
+	mu sync.Mutex
+	// The segment being appended to, opened on the first append after a
+	// rotation
+	current     *os.File
+	currentSeq  uint64
+	currentSize int64
+}
+
+// Open the spool in dir, creating it if needed. Segments left by a previous
+// process are kept and drained like any others.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	spool := &Spool{dir: dir, maxSegmentBytes: defaultSpoolSegmentBytes}
+
+	seqs, err := spool.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) > 0 {
+		spool.currentSeq = seqs[len(seqs)-1]
+	}
+
+	return spool, nil
+}
+
+func (spool *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(spool.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+}
+
+// Sequence numbers of every segment, oldest first.
+func (spool *Spool) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(spool.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), spoolSegmentSuffix)
+		if !ok {
+			continue
+		}
+		if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	return seqs, nil
+}
+
+// Write entry to the newest segment and sync it to disk before returning.
+func (spool *Spool) append(entry spoolEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	encoded = append(encoded, '\n')
+
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	if spool.current != nil && spool.currentSize+int64(len(encoded)) > spool.maxSegmentBytes {
+		spool.closeCurrent()
+	}
+	if spool.current == nil {
+		spool.currentSeq++
+		file, err := os.OpenFile(spool.segmentPath(spool.currentSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		spool.current, spool.currentSize = file, 0
+	}
+
+	if _, err := spool.current.Write(encoded); err != nil {
+		return err
+	}
+	spool.currentSize += int64(len(encoded))
+
+	return spool.current.Sync()
+}
+
+func (spool *Spool) closeCurrent() {
+	if spool.current != nil {
+		spool.current.Close()
+		spool.current = nil
+	}
+}
+
+// Stop appending to the newest segment, so every segment that exists now can
+// be drained, and return them oldest first.
+func (spool *Spool) rotate() ([]uint64, error) {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	spool.closeCurrent()
+
+	return spool.segments()
+}
+
+// Every entry in a closed segment, in the order they were appended. A line
+// cut short by a crash mid-append is skipped.
+func (spool *Spool) read(seq uint64) ([]spoolEntry, error) {
+	file, err := os.Open(spool.segmentPath(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []spoolEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), int(defaultSpoolSegmentBytes))
+	for scanner.Scan() {
+		var entry spoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func (spool *Spool) remove(seq uint64) error {
+	return os.Remove(spool.segmentPath(seq))
+}
+
+// Total bytes of every segment on disk.
+func (spool *Spool) Size() int64 {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	seqs, err := spool.segments()
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	for _, seq := range seqs {
+		if info, err := os.Stat(spool.segmentPath(seq)); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// How long the oldest spooled entry has been waiting, or zero if the spool is
+// empty.
+func (spool *Spool) OldestAge() time.Duration {
+	spool.mu.Lock()
+	seqs, err := spool.segments()
+	spool.mu.Unlock()
+	if err != nil {
+		return 0
+	}
+
+	for _, seq := range seqs {
+		file, err := os.Open(spool.segmentPath(seq))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), int(defaultSpoolSegmentBytes))
+		var entry spoolEntry
+		found := scanner.Scan() && json.Unmarshal(scanner.Bytes(), &entry) == nil
+		file.Close()
+		if found {
+			return time.Since(entry.SpooledAt)
+		}
+	}
+
+	return 0
+}
+
+// Returned when a release couldn't get into Redis and was spooled to disk to
+// be published once it is back
+var errSpooled = errors.New("spooled")
+
+// Fall back to spool when Redis can't take a release, instead of failing it.
+func (pipeline *Pipeline) SetSpool(spool *Spool) {
+	pipeline.spool = spool
+}
+
+// Write a release to the spool, to be published to publisher, or to every
+// publisher if it's nil, once Redis is back.
+func (pipeline *Pipeline) spoolRelease(publisher Publisher, publishing publishing) error {
+	if pipeline.spool == nil {
+		return errors.New("no spool configured")
+	}
+
+	entry := spoolEntry{
+		PackageVersion: publishing.PackageVersion,
+		TTLSeconds:     int64(publishing.ttl.Seconds()),
+		SpooledAt:      time.Now(),
+	}
+	if publisher != nil {
+		entry.Publisher = publisher.Name()
+	}
+	if err := pipeline.spool.append(entry); err != nil {
+		return err
+	}
+	metrics.SpooledReleases.Inc()
+
+	return nil
+}
+
+// If Redis is down, spool the whole batch and finish it. Reports whether it
+// did. Used when the batch's dedup check failed, so nothing has been
+// published yet.
+func (pipeline *Pipeline) spoolIfRedisDown(batch []publishing) bool {
+	if pipeline.spool == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if redis.Dedup().Ping(ctx).Err() == nil {
+		return false
+	}
+
+	log.WithFields(log.Fields{"publisher": "pipeline", "releases": len(batch)}).Warn("redis is down, spooling releases")
+	for _, publishing := range batch {
+		if err := pipeline.spoolRelease(nil, publishing); err != nil {
+			log.WithFields(log.Fields{"publisher": "pipeline", "error": err}).Error("couldn't spool release")
+			publishing.finish(err)
+			continue
+		}
+		publishing.finish(nil)
+	}
+
+	return true
+}
+
+// Replay the spool through the pipeline every interval while Redis is up,
+// until ctx is done. Zero uses the default of 5s.
+func (pipeline *Pipeline) DrainSpool(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSpoolDrainInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := pipeline.drainSpool(ctx); err != nil {
+			log.WithFields(log.Fields{"publisher": "pipeline", "error": err}).Warn("couldn't drain spool")
+		}
+	}
+}
+
+// Replay every segment that exists now, oldest first, deleting each once all
+// of its releases have been through the pipeline. Anything that still can't
+// get into Redis is spooled again into a newer segment.
+func (pipeline *Pipeline) drainSpool(ctx context.Context) error {
+	spool := pipeline.spool
+	if spool == nil {
+		return nil
+	}
+	if seqs, err := spool.segments(); err != nil || len(seqs) == 0 {
+		return err
+	}
+	if err := redis.Dedup().Ping(ctx).Err(); err != nil {
+		return err
+	}
+
+	seqs, err := spool.rotate()
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		entries, err := spool.read(seq)
+		if err != nil {
+			return err
+		}
+		if err := pipeline.replaySpooled(ctx, entries); err != nil {
+			return err
+		}
+		if err := spool.remove(seq); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"publisher": "pipeline", "releases": len(entries)}).Info("drained spool segment")
+	}
+
+	return nil
+}
+
+// Enqueue entries in order and wait until the pipeline has processed them.
+func (pipeline *Pipeline) replaySpooled(ctx context.Context, entries []spoolEntry) error {
+	results := make(chan error, len(entries))
+	enqueued := 0
+	for _, entry := range entries {
+		publishing := publishing{
+			PackageVersion: entry.PackageVersion,
+			ttl:            time.Duration(entry.TTLSeconds) * time.Second,
+			result:         results,
+		}
+		// TTLs are kept in whole seconds
+		if publishing.ttl <= 0 {
+			publishing.ttl = defaultReplayTTL
+		}
+		if entry.Publisher != "" {
+			publishing.only = pipeline.publisher(entry.Publisher)
+			if publishing.only == nil {
+				log.WithFields(log.Fields{"publisher": entry.Publisher}).Warn("spooled release's publisher isn't registered, skipping")
+				continue
+			}
+			// Spilled from a full pool, so back into the pool
+			if pool := pipeline.poolFor(publishing.only); pool != nil {
+				if err := pool.submit(ctx, publishing); err != nil {
+					return err
+				}
+				enqueued++
+				continue
+			}
+		}
+		if err := pipeline.enqueue(ctx, publishing); err != nil {
+			return err
+		}
+		enqueued++
+	}
+
+	for range enqueued {
+		select {
+		case err := <-results:
+			// Dead letters have their own replay, so they are done with here
+			if err != nil && !isParked(err) {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Whether err means the release was put aside to be published later, as a
+// dead letter or in the spool, rather than lost.
+func isParked(err error) bool {
+	return errors.Is(err, errDeadLettered) || errors.Is(err, errSpooled)
+}
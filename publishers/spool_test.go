@@ -0,0 +1,189 @@
+package publishers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/data"
+)
+
+func TestSpool_SegmentsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.maxSegmentBytes = 200
+
+	if spool.Size() != 0 || spool.OldestAge() != 0 {
+		t.Errorf("empty spool has size %d and age %s", spool.Size(), spool.OldestAge())
+	}
+	spooledAt := time.Now().Add(-time.Minute)
+	for _, packageVersion := range testPackageVersions(5) {
+		if err := spool.append(spoolEntry{PackageVersion: packageVersion, SpooledAt: spooledAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if age := spool.OldestAge(); age < time.Minute || age > 2*time.Minute {
+		t.Errorf("oldest age = %s, want a minute", age)
+	}
+
+	// A process that crashed mid-append leaves a torn line behind
+	seqs, err := spool.rotate()
+	if err != nil || len(seqs) < 2 {
+		t.Fatalf("segments = %v, %v, want the entries split over several", seqs, err)
+	}
+	last, err := os.OpenFile(spool.segmentPath(seqs[len(seqs)-1]), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last.WriteString(`{"package_version":{"platf`)
+	last.Close()
+
+	// Another process picks up where this one left off
+	reopened, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.append(spoolEntry{PackageVersion: data.PackageVersion{Platform: "npm", Name: "pkg", Version: "f"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	seqs, err = reopened.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions string
+	for _, seq := range seqs {
+		entries, err := reopened.read(seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			versions += entry.PackageVersion.Version
+		}
+	}
+	if versions != "abcdef" {
+		t.Errorf("read back %q, want abcdef", versions)
+	}
+	if reopened.Size() == 0 {
+		t.Error("expected the spool to report its size")
+	}
+}
+
+func TestPipeline_SpoolsWhileRedisIsDown(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	spool, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &recordingPublisher{}
+	pipeline := newTestPipeline(publisher)
+	pipeline.SetSpool(spool)
+
+	server.Close()
+	// The releases are safe on disk, so the ingestor can move on
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(3)); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 0 {
+		t.Fatalf("published %d releases without a dedup check", len(publisher.published))
+	}
+	if err := pipeline.drainSpool(ctx); err == nil {
+		t.Error("expected draining to wait for Redis")
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.drainSpool(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != 3 || publisher.published[0].Version != "a" || publisher.published[2].Version != "c" {
+		t.Errorf("published %+v, want the spooled releases in order", publisher.published)
+	}
+	if !server.Exists((&publishing{PackageVersion: testPackageVersions(1)[0]}).Key()) {
+		t.Error("expected drained releases to be deduplicated again")
+	}
+	if spool.Size() != 0 {
+		t.Errorf("spool still has %d bytes after draining", spool.Size())
+	}
+}
+
+func TestPipeline_SpoolsThroughSidekiqWhileRedisIsDown(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	spool, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := newTestPipeline(NewSidekiq())
+	pipeline.SetSpool(spool)
+
+	server.Close()
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.drainSpool(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs, _ := server.List("queue:critical"); len(jobs) != 2 {
+		t.Errorf("enqueued %d jobs after draining, want 2", len(jobs))
+	}
+}
+
+// Fails its first call and takes Redis down with it, so the release can't be
+// dead-lettered either.
+type outagePublisher struct {
+	recordingPublisher
+	outage func()
+}
+
+func (publisher *outagePublisher) Publish(packageVersion data.PackageVersion) error {
+	if publisher.outage != nil {
+		publisher.outage()
+		publisher.outage = nil
+		return errors.New("connection reset")
+	}
+
+	return publisher.recordingPublisher.Publish(packageVersion)
+}
+
+func TestPipeline_SpoolsWhatCantBeDeadLettered(t *testing.T) {
+	server := setupTestRedis(t)
+	ctx := context.Background()
+	spool, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &outagePublisher{outage: server.Close}
+	pipeline := newTestPipeline(publisher)
+	// Give up straight away rather than retrying into the outage
+	pipeline.retry.maxAttempts = 1
+	pipeline.SetSpool(spool)
+
+	if err := pipeline.PublishAll(ctx, time.Hour, testPackageVersions(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.drainSpool(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(publisher.published) != 1 {
+		t.Errorf("published %d releases after draining, want 1", len(publisher.published))
+	}
+	if deadLetters, _ := pipeline.DeadLetters(ctx); len(deadLetters) != 0 {
+		t.Errorf("got dead letters %+v, want the release published instead", deadLetters)
+	}
+}
@@ -0,0 +1,148 @@
+package publishers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/librariesio/depper/data"
+)
+
+// The header carrying the hex HMAC-SHA256 of the request body, keyed with the
+// endpoint's secret, as "sha256=<hex>".
+const WebhookSignatureHeader = "X-Depper-Signature"
+
+// A URL to POST releases to.
+type WebhookEndpoint struct {
+	URL string `yaml:"url"`
+	// Signs each request body. Requests are unsigned without one
+	Secret string `yaml:"secret"`
+	// Only releases from these platforms are sent. Empty sends every release
+	Platforms []string `yaml:"platforms"`
+}
+
+type WebhookOptions struct {
+	Endpoints []WebhookEndpoint `yaml:"endpoints"`
+}
+
+// POSTs each release as a CloudEvent to every endpoint whose platforms match.
+type Webhook struct {
+	endpoints []WebhookEndpoint
+	client    *http.Client
+	retry     retryPolicy
+}
+
+func NewWebhook(options WebhookOptions) (*Webhook, error) {
+	if len(options.Endpoints) == 0 {
+		return nil, errors.New("webhook publisher needs at least one endpoint")
+	}
+	for i, endpoint := range options.Endpoints {
+		if endpoint.URL == "" {
+			return nil, fmt.Errorf("webhook endpoint %d has no url", i)
+		}
+	}
+
+	return &Webhook{
+		endpoints: options.Endpoints,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		retry:     defaultRetryPolicy,
+	}, nil
+}
+
+func (webhook *Webhook) Name() string {
+	return "webhook"
+}
+
+// Deliver packageVersion to every matching endpoint. Each endpoint is retried
+// on its own, so one that is down doesn't get the others sent duplicates.
+// Whatever still fails is returned as Permanent, as retrying the whole
+// release would only resend it to the endpoints that already have it.
+func (webhook *Webhook) Publish(packageVersion data.PackageVersion) error {
+	body, err := marshalReleaseEvent(packageVersion)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	var errs []error
+	for _, endpoint := range webhook.endpoints {
+		if len(endpoint.Platforms) > 0 && !slices.Contains(endpoint.Platforms, packageVersion.Platform) {
+			continue
+		}
+		if err := webhook.deliver(endpoint, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Permanent(err)
+	}
+
+	return nil
+}
+
+// POST body to endpoint, retrying network errors and 5xx responses.
+func (webhook *Webhook) deliver(endpoint WebhookEndpoint, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= webhook.retry.maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(webhook.retry.backoff(attempt - 1))
+		}
+
+		var retryable bool
+		retryable, err = webhook.post(endpoint, body)
+		if err == nil || !retryable {
+			break
+		}
+		log.WithFields(log.Fields{"url": endpoint.URL, "attempt": attempt, "error": err}).Warn("webhook delivery failed")
+	}
+
+	return err
+}
+
+// Make a single delivery. Reports whether a failure is worth retrying.
+func (webhook *Webhook) post(endpoint WebhookEndpoint, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", CloudEventContentType)
+	req.Header.Set("User-Agent", "depper")
+	if endpoint.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(endpoint.Secret, body))
+	}
+
+	response, err := webhook.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 500 {
+		return true, fmt.Errorf("POST %s: %s", endpoint.URL, response.Status)
+	}
+	if response.StatusCode >= 300 {
+		return false, fmt.Errorf("POST %s: %s", endpoint.URL, response.Status)
+	}
+
+	return false, nil
+}
+
+// The value of WebhookSignatureHeader for body, for receivers to compare
+// against with hmac.Equal.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package publishers
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/data"
+)
+
+// Answers with each status in turn, then 200, counting requests and
+// checking their signatures. An empty secret expects unsigned requests.
+func newWebhookServer(t *testing.T, secret string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+
+		body, _ := io.ReadAll(r.Body)
+		signature := r.Header.Get(WebhookSignatureHeader)
+		if secret == "" && signature != "" {
+			t.Errorf("unexpected signature %q without a secret", signature)
+		} else if secret != "" && !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, body))) {
+			t.Errorf("signature %q doesn't match the body", signature)
+		}
+		var event CloudEvent
+		if err := json.Unmarshal(body, &event); err != nil || event.Type != ReleasePublishedType || event.Data.Name != "left-pad" {
+			t.Errorf("unexpected body %s: %v", body, err)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != CloudEventContentType {
+			t.Errorf("Content-Type = %q, want %q", contentType, CloudEventContentType)
+		}
+
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestWebhook_Publish(t *testing.T) {
+	tests := []struct {
+		name         string
+		platforms    []string
+		statuses     []int
+		wantRequests int32
+		wantErr      bool
+	}{
+		{name: "delivered", wantRequests: 1},
+		{name: "other platform", platforms: []string{"pypi"}, wantRequests: 0},
+		{name: "matching platform", platforms: []string{"pypi", "npm"}, wantRequests: 1},
+		{name: "retried after 5xx", statuses: []int{500, 503}, wantRequests: 3},
+		{name: "gives up after 5xx", statuses: []int{500, 500, 500}, wantRequests: 3, wantErr: true},
+		{name: "not retried after 4xx", statuses: []int{400}, wantRequests: 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newWebhookServer(t, "s3cret", test.statuses...)
+			webhook, err := NewWebhook(WebhookOptions{Endpoints: []WebhookEndpoint{
+				{URL: server.URL, Secret: "s3cret", Platforms: test.platforms},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			webhook.retry = retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+
+			err = webhook.Publish(data.PackageVersion{Platform: "npm", Name: "left-pad", Version: "1.3.0"})
+			if (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil && !isPermanent(err) {
+				t.Errorf("expected the pipeline not to retry %v", err)
+			}
+			if got := requests.Load(); got != test.wantRequests {
+				t.Errorf("got %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWebhook_RetriesEachEndpointOnItsOwn(t *testing.T) {
+	healthy, healthyRequests := newWebhookServer(t, "")
+	flaky, flakyRequests := newWebhookServer(t, "", 502)
+	webhook, err := NewWebhook(WebhookOptions{Endpoints: []WebhookEndpoint{{URL: healthy.URL}, {URL: flaky.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.retry = retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+
+	if err := webhook.Publish(data.PackageVersion{Platform: "npm", Name: "left-pad", Version: "1.3.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if healthyRequests.Load() != 1 || flakyRequests.Load() != 2 {
+		t.Errorf("got %d and %d requests, want 1 and 2", healthyRequests.Load(), flakyRequests.Load())
+	}
+}
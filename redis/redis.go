@@ -2,29 +2,99 @@ package redis
 
 import (
 	"context"
-	"os"
-
-	log "github.com/sirupsen/logrus"
+	"errors"
+	"fmt"
 
 	redis "github.com/go-redis/redis/v8"
 )
 
-var Client *redis.Client
+// The connection for everything that doesn't have one of its own.
+var Client redis.UniversalClient
+
+// Separate connections for bookmarks, dedup keys and the Sidekiq queue. Nil
+// shares Client.
+var BookmarksClient, DedupClient, QueueClient redis.UniversalClient
+
 var Nil = redis.Nil
 
-// Open a connection to Redis
-func Connect() {
-	address := "localhost:6379"
-	if envVal, envFound := os.LookupEnv("REDISCLOUD_URL"); envFound {
-		address = envVal
+// The URL of each connection. Empty URLs share Default's connection, which is
+// redis://localhost:6379 unless set.
+type URLs struct {
+	Default   string
+	Bookmarks string
+	Dedup     string
+	Queue     string
+}
+
+// Open the connections in urls. They are usable even if Redis can't be
+// reached yet, and reconnect once it can. An invalid URL leaves the existing
+// connections in place.
+func Connect(urls URLs) error {
+	if urls.Default == "" {
+		urls.Default = "redis://localhost:6379"
+	}
+
+	// Only replace the connections once every URL has parsed
+	clients := make([]redis.UniversalClient, 4)
+	for i, url := range []string{urls.Default, urls.Bookmarks, urls.Dedup, urls.Queue} {
+		if url == "" || (i > 0 && url == urls.Default) {
+			continue
+		}
+		client, err := NewClient(url)
+		if err != nil {
+			return fmt.Errorf("Error connecting to redis: %w", err)
+		}
+		clients[i] = client
 	}
+	Client, BookmarksClient, DedupClient, QueueClient = clients[0], clients[1], clients[2], clients[3]
 
-	Client = redis.NewClient(&redis.Options{
-		Addr:     address,
-		Password: "",
-		DB:       0,
-	})
-	if err := Client.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("Error connecting to redis: %s", err.Error())
+	if err := Ping(context.Background()); err != nil {
+		return fmt.Errorf("Error connecting to redis: %w", err)
 	}
+
+	return nil
+}
+
+func Bookmarks() redis.UniversalClient {
+	return orDefault(BookmarksClient)
+}
+
+func Dedup() redis.UniversalClient {
+	return orDefault(DedupClient)
+}
+
+func Queue() redis.UniversalClient {
+	return orDefault(QueueClient)
+}
+
+func orDefault(client redis.UniversalClient) redis.UniversalClient {
+	if client == nil {
+		return Client
+	}
+
+	return client
+}
+
+// Check every connection is up.
+func Ping(ctx context.Context) error {
+	var errs []error
+	for _, client := range []redis.UniversalClient{Client, BookmarksClient, DedupClient, QueueClient} {
+		if client == nil {
+			continue
+		}
+		if err := client.Ping(ctx).Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Whether one Lua script can use keys from both a and b: they have to be the
+// same connection, and not a cluster, where a script's keys must share a hash
+// slot.
+func Colocated(a, b redis.UniversalClient) bool {
+	_, isCluster := a.(*redis.ClusterClient)
+
+	return a == b && !isCluster
 }
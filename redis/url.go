@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+type topology int
+
+const (
+	standalone topology = iota
+	sentinel
+	cluster
+)
+
+// Where a URL points: one server, a master found through Sentinel, or a
+// cluster.
+type connection struct {
+	topology topology
+	options  *redis.UniversalOptions
+}
+
+// Parse a Redis URL, one of
+//
+//	redis://[[user]:password@]host[:port][/db]
+//	redis+sentinel://[[user]:password@]host[:port][,host[:port]...][/db]?master=name[&sentinel_password=password]
+//	redis+cluster://[[user]:password@]host[:port][,host[:port]...]
+//
+// rediss, rediss+sentinel and rediss+cluster connect over TLS. A bare
+// host:port, as REDISCLOUD_URL used to be set to, is a plain connection.
+func parseURL(rawURL string) (connection, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "redis://" + rawURL
+	}
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+
+	// url.Parse only takes one host, so take the list out first
+	authority, tail := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority, tail = rest[:i], rest[i:]
+	}
+	userInfo, hosts := "", authority
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userInfo, hosts = authority[:i+1], authority[i+1:]
+	}
+	parsed, err := url.Parse(scheme + "://" + userInfo + "hosts" + tail)
+	if err != nil {
+		return connection{}, err
+	}
+
+	conn := connection{options: &redis.UniversalOptions{}}
+	options := conn.options
+	defaultPort := "6379"
+	base, variant, _ := strings.Cut(parsed.Scheme, "+")
+	switch variant {
+	case "":
+	case "sentinel":
+		conn.topology = sentinel
+		defaultPort = "26379"
+	case "cluster":
+		conn.topology = cluster
+	default:
+		return connection{}, fmt.Errorf("invalid redis URL scheme %q", parsed.Scheme)
+	}
+	switch base {
+	case "redis":
+	case "rediss":
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	default:
+		return connection{}, fmt.Errorf("invalid redis URL scheme %q", parsed.Scheme)
+	}
+
+	for _, host := range strings.Split(hosts, ",") {
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+		}
+		options.Addrs = append(options.Addrs, host)
+	}
+	if len(options.Addrs) == 0 {
+		options.Addrs = []string{net.JoinHostPort("localhost", defaultPort)}
+	}
+	if conn.topology == standalone && len(options.Addrs) > 1 {
+		return connection{}, fmt.Errorf("redis URL has %d hosts, use redis+sentinel or redis+cluster for more than one", len(options.Addrs))
+	}
+
+	if parsed.User != nil {
+		options.Username = parsed.User.Username()
+		options.Password, _ = parsed.User.Password()
+	}
+
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		if options.DB, err = strconv.Atoi(db); err != nil {
+			return connection{}, fmt.Errorf("invalid redis database %q", db)
+		}
+		if conn.topology == cluster && options.DB != 0 {
+			return connection{}, fmt.Errorf("redis cluster only has database 0")
+		}
+	}
+
+	for key, values := range parsed.Query() {
+		switch {
+		case key == "master" && conn.topology == sentinel:
+			options.MasterName = values[0]
+		case key == "sentinel_password" && conn.topology == sentinel:
+			options.SentinelPassword = values[0]
+		default:
+			return connection{}, fmt.Errorf("unknown redis URL parameter %q", key)
+		}
+	}
+	if conn.topology == sentinel && options.MasterName == "" {
+		return connection{}, fmt.Errorf("redis+sentinel URL needs a master")
+	}
+
+	return conn, nil
+}
+
+// Connect to rawURL. The client is usable even if Redis can't be reached yet,
+// and reconnects once it can.
+func NewClient(rawURL string) (redis.UniversalClient, error) {
+	conn, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conn.topology {
+	case sentinel:
+		return redis.NewFailoverClient(conn.options.Failover()), nil
+	case cluster:
+		return redis.NewClusterClient(conn.options.Cluster()), nil
+	default:
+		return redis.NewClient(conn.options.Simple()), nil
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/metrics"
+	"github.com/librariesio/depper/publishers"
+	"github.com/librariesio/depper/redis"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Readiness fails once the pipeline queue is this full, since ingestors
+// will soon block trying to publish.
+const maxQueueSaturation = 0.9
+
+type pipelineStatus struct {
+	QueueDepth    int                        `json:"queue_depth"`
+	QueueCapacity int                        `json:"queue_capacity"`
+	DedupCache    publishers.DedupCacheStats `json:"dedup_cache"`
+	Pools         []publishers.PoolStatus    `json:"pools"`
+}
+
+type status struct {
+	Ingestors []ingestorStatus `json:"ingestors"`
+	Pipeline  pipelineStatus   `json:"pipeline"`
+}
+
+func (depper *Depper) serveMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", depper.handleHealthz)
+	mux.HandleFunc("GET /readyz", depper.handleReadyz)
+	mux.HandleFunc("GET /status", depper.handleStatus)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	if depper.adminToken != "" {
+		depper.registerAdminRoutes(mux)
+	}
+
+	return mux
+}
+
+// Serve the health, readiness and status endpoints in the background.
+func (depper *Depper) startServer(addr string) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           depper.serveMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.WithFields(log.Fields{"addr": addr}).Info("Starting HTTP server")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{"addr": addr, "error": err}).Error("HTTP server failed")
+		}
+	}()
+
+	return server
+}
+
+// Register the metrics that are read from depper's own state at scrape time.
+func (depper *Depper) registerMetrics() {
+	metrics.RegisterQueueDepth(depper.pipeline.QueueDepth)
+	if depper.spool != nil {
+		metrics.RegisterSpool(depper.spool.Size, depper.spool.OldestAge)
+	}
+	metrics.RegisterBookmarkAge(func() map[string]time.Time {
+		depper.mu.Lock()
+		defer depper.mu.Unlock()
+
+		updatedAt := make(map[string]time.Time, len(depper.scheduled))
+		for _, scheduled := range depper.scheduled {
+			if commit := scheduled.lastBookmarkCommit(); !commit.IsZero() {
+				updatedAt[scheduled.ingestor.Name()] = commit
+			}
+		}
+
+		return updatedAt
+	})
+}
+
+// The process is up and serving requests.
+func (depper *Depper) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// Redis is reachable and the pipeline has room for more releases.
+func (depper *Depper) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := redis.Ping(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("redis unreachable: %s", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	depth, capacity := depper.pipeline.QueueDepth(), depper.pipeline.QueueCapacity()
+	if float64(depth) >= float64(capacity)*maxQueueSaturation {
+		http.Error(w, fmt.Sprintf("pipeline queue saturated: %d/%d", depth, capacity), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func (depper *Depper) handleStatus(w http.ResponseWriter, r *http.Request) {
+	depper.mu.Lock()
+	scheduled := depper.scheduled
+	depper.mu.Unlock()
+
+	response := status{
+		Ingestors: make([]ingestorStatus, 0, len(scheduled)),
+		Pipeline: pipelineStatus{
+			QueueDepth:    depper.pipeline.QueueDepth(),
+			QueueCapacity: depper.pipeline.QueueCapacity(),
+			DedupCache:    depper.pipeline.DedupCacheStats(),
+			Pools:         depper.pipeline.PoolStatuses(),
+		},
+	}
+
+	for _, ingestor := range scheduled {
+		ingestorStatus := ingestor.currentStatus()
+
+		bookmark, err := depper.bookmarks.Get(r.Context(), ingestorStatus.Name)
+		if err == nil {
+			ingestorStatus.Bookmark = bookmark
+		} else if !errors.Is(err, ingestors.ErrNoBookmark) {
+			log.WithFields(log.Fields{"ingestor": ingestorStatus.Name, "error": err}).Warn("couldn't read bookmark for status")
+		}
+
+		response.Ingestors = append(response.Ingestors, ingestorStatus)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("couldn't write status")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+)
+
+type fakeIngestor struct{}
+
+func (fakeIngestor) Name() string     { return "fake" }
+func (fakeIngestor) Schedule() string { return "@every 1m" }
+func (fakeIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *ingestors.Cursor, error) {
+	return nil, nil, nil
+}
+
+func TestHandleStatus(t *testing.T) {
+	store, err := ingestors.NewBookmarkStore("file", filepath.Join(t.TempDir(), "bookmarks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(context.Background(), "fake", "42"); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled := newScheduledIngestor(fakeIngestor{}, config.Ingestor{})
+	scheduled.recordStart()
+	scheduled.recordFinish(3, errors.New("boom"))
+
+	depper := &Depper{
+		pipeline:  publishers.NewPipeline(),
+		bookmarks: store,
+		scheduled: []*scheduledIngestor{scheduled},
+	}
+
+	recorder := httptest.NewRecorder()
+	depper.serveMux().ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status code = %d", recorder.Code)
+	}
+
+	var got status
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Ingestors) != 1 {
+		t.Fatalf("got %d ingestors", len(got.Ingestors))
+	}
+	ingestor := got.Ingestors[0]
+	if ingestor.Name != "fake" || ingestor.Schedule != "@every 1m" || ingestor.Bookmark != "42" {
+		t.Errorf("unexpected status %+v", ingestor)
+	}
+	if ingestor.LastResults != 3 || ingestor.LastError != "boom" || ingestor.LastSuccessAt != nil || ingestor.LastFinishedAt == nil {
+		t.Errorf("unexpected run status %+v", ingestor)
+	}
+	if got.Pipeline.QueueCapacity == 0 {
+		t.Errorf("expected pipeline capacity")
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	depper := &Depper{pipeline: publishers.NewPipeline()}
+
+	recorder := httptest.NewRecorder()
+	depper.serveMux().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status code = %d", recorder.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/publishers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultShutdownRunTimeout   = 20 * time.Second
+	defaultShutdownDrainTimeout = 10 * time.Second
+)
+
+var errShuttingDown = errors.New("depper is shutting down")
+
+// Track a run so shutdown can wait for it. Returns false once shutdown has
+// started, in which case the run shouldn't start.
+func (depper *Depper) beginRun() bool {
+	depper.mu.Lock()
+	defer depper.mu.Unlock()
+
+	if depper.stopping {
+		return false
+	}
+	depper.runs.Add(1)
+
+	return true
+}
+
+// Stop scheduling runs, give in-flight runs until the run timeout to finish
+// before cancelling them, then publish everything left in the pipeline
+// within the drain timeout. Anything that couldn't be published is logged.
+func (depper *Depper) shutdown(server *http.Server, cfg config.Shutdown) {
+	runTimeout := cfg.RunTimeout
+	if runTimeout <= 0 {
+		runTimeout = defaultShutdownRunTimeout
+	}
+
+	depper.mu.Lock()
+	depper.stopping = true
+	scheduled := depper.scheduled
+	depper.mu.Unlock()
+
+	for _, ingestor := range scheduled {
+		ingestor.cron.Stop()
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	defer cancel()
+
+	// Stop taking admin requests that could start new runs or publishings.
+	if server != nil {
+		if err := server.Shutdown(runCtx); err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("HTTP server didn't shut down cleanly")
+		}
+	}
+
+	runsDone := make(chan struct{})
+	go func() {
+		depper.runs.Wait()
+		close(runsDone)
+	}()
+
+	select {
+	case <-runsDone:
+	case <-runCtx.Done():
+		for _, ingestor := range scheduled {
+			if ingestor.currentStatus().Running {
+				log.WithFields(log.Fields{"ingestor": ingestor.ingestor.Name()}).Warn("cancelling in-flight run, its bookmark won't be committed")
+			}
+		}
+		depper.cancel()
+		<-runsDone
+	}
+
+	_ = drainPipeline(depper.pipeline, cfg.DrainTimeout)
+
+	depper.cancel()
+}
+
+// Close the pipeline, giving what's queued, including in publisher pools,
+// until drainTimeout to be published. Anything dropped is logged and
+// reported as an error.
+func drainPipeline(pipeline *publishers.Pipeline, drainTimeout time.Duration) error {
+	if drainTimeout <= 0 {
+		drainTimeout = defaultShutdownDrainTimeout
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	log.WithFields(log.Fields{"queued": pipeline.QueueDepth()}).Info("draining pipeline")
+	dropped := pipeline.Close(drainCtx)
+	for _, packageVersion := range dropped {
+		log.WithFields(log.Fields{
+			"platform": packageVersion.Platform,
+			"name":     packageVersion.Name,
+			"version":  packageVersion.Version,
+		}).Error("dropped release during shutdown")
+	}
+	if len(dropped) > 0 {
+		log.WithFields(log.Fields{"dropped": len(dropped)}).Error("pipeline didn't drain before the deadline")
+		return fmt.Errorf("dropped %d releases that weren't published before the drain timeout", len(dropped))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/librariesio/depper/config"
+	"github.com/librariesio/depper/data"
+	"github.com/librariesio/depper/ingestors"
+	"github.com/librariesio/depper/publishers"
+	"github.com/robfig/cron/v3"
+)
+
+// Blocks until its context is cancelled.
+type hangingIngestor struct {
+	fakeIngestor
+	started chan struct{}
+}
+
+func (ingestor hangingIngestor) Ingest(ctx context.Context) ([]data.PackageVersion, *ingestors.Cursor, error) {
+	close(ingestor.started)
+	<-ctx.Done()
+
+	return nil, nil, ctx.Err()
+}
+
+func TestShutdown_CancelsRunsAfterTimeout(t *testing.T) {
+	setupTestRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	depper := &Depper{pipeline: publishers.NewPipeline(), ctx: ctx, cancel: cancel}
+
+	ingestor := hangingIngestor{started: make(chan struct{})}
+	scheduled := newScheduledIngestor(ingestor, config.Ingestor{})
+	scheduled.cron = cron.New()
+	depper.scheduled = []*scheduledIngestor{scheduled}
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- depper.ingestAndPublish(scheduled) }()
+	<-ingestor.started
+
+	depper.shutdown(nil, config.Shutdown{RunTimeout: 50 * time.Millisecond, DrainTimeout: time.Second})
+
+	if err := <-runErr; err == nil {
+		t.Error("expected the in-flight run to be cancelled")
+	}
+	if err := depper.ingestAndPublish(scheduled); err != errShuttingDown {
+		t.Errorf("run after shutdown = %v, want errShuttingDown", err)
+	}
+}
+
+func TestDrainPipeline_WaitsForPools(t *testing.T) {
+	tests := []struct {
+		drainTimeout time.Duration
+		wantErr      bool
+	}{
+		{drainTimeout: time.Second},
+		// The queued release is dropped once the publisher gets unstuck
+		{drainTimeout: 10 * time.Millisecond, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.drainTimeout.String(), func(t *testing.T) {
+			setupTestRedis(t)
+			started := make(chan struct{}, 2)
+			publisher := &stuckPublisher{onPublish: func() { started <- struct{}{} }, release: make(chan struct{})}
+			pipeline := publishers.NewPipeline()
+			if err := pipeline.RegisterPool(publisher, publishers.PoolOptions{Workers: 1}); err != nil {
+				t.Fatal(err)
+			}
+			// The second release waits in the queue while the first is stuck
+			for _, version := range []string{"1.3.0", "1.3.1"} {
+				packageVersion := data.PackageVersion{Platform: "npm", Name: "left-pad", Version: version}
+				if err := pipeline.PublishAll(context.Background(), time.Hour, []data.PackageVersion{packageVersion}); err != nil {
+					t.Fatal(err)
+				}
+				if version == "1.3.0" {
+					<-started
+				}
+			}
+
+			time.AfterFunc(50*time.Millisecond, func() { close(publisher.release) })
+			if err := drainPipeline(pipeline, test.drainTimeout); (err != nil) != test.wantErr {
+				t.Errorf("drainPipeline() = %v, want an error: %t", err, test.wantErr)
+			}
+		})
+	}
+}